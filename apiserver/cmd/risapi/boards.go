@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// boardUpdate is sent to board subscribers, Changes is nil when the update is a full board
+type boardUpdate struct {
+	Board   ris.DeparturesResponse
+	Changes []ris.DepartureChange
+}
+
+// boardHub refreshes the departure boards clients are subscribed to in the background,
+// every board is refreshed by one loop no matter how many clients watch it
+type boardHub struct {
+	ctx         context.Context
+	interval    time.Duration
+	timeout     time.Duration
+	concurrency int
+
+	mu     sync.Mutex
+	boards map[string]*watchedBoard
+}
+
+type watchedBoard struct {
+	key      string
+	stations []string
+	query    url.Values
+
+	mu   sync.Mutex
+	last *ris.DeparturesResponse
+	subs map[chan boardUpdate]struct{}
+	// byStation holds the last departures fetched without warnings per station
+	byStation map[string][]ris.Departure
+}
+
+func newBoardHub(ctx context.Context, interval, timeout time.Duration, concurrency int) *boardHub {
+	return &boardHub{
+		ctx:         ctx,
+		interval:    interval,
+		timeout:     timeout,
+		concurrency: concurrency,
+		boards:      map[string]*watchedBoard{},
+	}
+}
+
+// subscribe starts watching the board of the stations for the board query parameters. The
+// first update on the channel is the full board, the ones after only hold the changes. The
+// channel is closed when the subscriber can not keep up or the hub stops, unsubscribe has
+// to be called when the subscriber is done.
+func (h *boardHub) subscribe(stations []string, query url.Values) (<-chan boardUpdate, func()) {
+	key := strings.Join(stations, ",") + "?" + query.Encode()
+	ch := make(chan boardUpdate, 8)
+
+	h.mu.Lock()
+	board, ok := h.boards[key]
+	if !ok {
+		board = &watchedBoard{
+			key:       key,
+			stations:  stations,
+			query:     query,
+			subs:      map[chan boardUpdate]struct{}{},
+			byStation: map[string][]ris.Departure{},
+		}
+		h.boards[key] = board
+		go h.watch(board)
+	}
+	board.mu.Lock()
+	board.subs[ch] = struct{}{}
+	if board.last != nil {
+		ch <- boardUpdate{Board: *board.last}
+	}
+	board.mu.Unlock()
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		board.mu.Lock()
+		defer board.mu.Unlock()
+		if _, ok := board.subs[ch]; ok {
+			delete(board.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// watch refreshes a board until it has no subscribers left
+func (h *boardHub) watch(board *watchedBoard) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.refresh(board)
+
+		select {
+		case <-ticker.C:
+		case <-h.ctx.Done():
+		}
+
+		h.mu.Lock()
+		board.mu.Lock()
+		if len(board.subs) == 0 || h.ctx.Err() != nil {
+			for ch := range board.subs {
+				delete(board.subs, ch)
+				close(ch)
+			}
+			delete(h.boards, board.key)
+			board.mu.Unlock()
+			h.mu.Unlock()
+			return
+		}
+		board.mu.Unlock()
+		h.mu.Unlock()
+	}
+}
+
+// refresh fetches the board and sends the subscribers what changed since the last fetch.
+// Every station is fetched on its own, so a station that fails keeps its last departures
+// rather than having them all reported as removed until it is back.
+func (h *boardHub) refresh(board *watchedBoard) {
+	// parse the query on every refresh so a timeOffset stays relative to now
+	opts, err := ris.ParseBoardOptions(board.query)
+	if err != nil {
+		log.Printf("invalid board options for %s: %v", board.key, err)
+		return
+	}
+	// subscribers expect changes within an interval, not once per liveboard cache TTL
+	opts.MaxAge = h.interval
+
+	stationDepartures := make([][]ris.Departure, len(board.stations))
+	stationWarnings := make([][]ris.Warning, len(board.stations))
+	ctx, cancel := context.WithTimeout(h.ctx, h.timeout)
+	sem := make(chan struct{}, h.concurrency)
+	var wg sync.WaitGroup
+	for i, station := range board.stations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			stationDepartures[i], stationWarnings[i] = ris.FetchDepartures(ctx, []string{station}, opts, 1)
+		}()
+	}
+	wg.Wait()
+	cancel()
+
+	board.mu.Lock()
+	defer board.mu.Unlock()
+
+	departures := []ris.Departure{}
+	warnings := []ris.Warning{}
+	for i, station := range board.stations {
+		if len(stationWarnings[i]) == 0 {
+			board.byStation[station] = stationDepartures[i]
+			departures = append(departures, stationDepartures[i]...)
+			continue
+		}
+		warnings = append(warnings, stationWarnings[i]...)
+		for _, d := range board.byStation[station] {
+			if !d.Time.Before(opts.Start()) {
+				departures = append(departures, d)
+			}
+		}
+	}
+	if len(warnings) == len(board.stations) {
+		log.Printf("refresh of board %s failed: %s", board.key, warnings[0].Text)
+	}
+
+	sort.SliceStable(departures, func(i, j int) bool {
+		return departures[i].TimeSchedule.Before(departures[j].TimeSchedule)
+	})
+	if opts.MaxResults > 0 && len(departures) > opts.MaxResults {
+		departures = departures[:opts.MaxResults]
+	}
+
+	resp := ris.DeparturesResponse{
+		Departures:  departures,
+		Disruptions: ris.CollectDisruptions(departures, func(d ris.Departure) []ris.Disruption { return d.Disruptions }),
+		Warnings:    warnings,
+	}
+	update := boardUpdate{Board: resp}
+	if board.last != nil {
+		update.Changes = ris.DiffDepartures(board.last.Departures, departures)
+		if len(update.Changes) == 0 {
+			board.last = &resp
+			return
+		}
+	}
+	board.last = &resp
+	board.send(update)
+}
+
+// send passes an update to all subscribers, the board has to be locked
+func (board *watchedBoard) send(update boardUpdate) {
+	for ch := range board.subs {
+		select {
+		case ch <- update:
+		default:
+			// a subscriber that misses a diff is out of sync, drop it so it reconnects
+			delete(board.subs, ch)
+			close(ch)
+		}
+	}
+}
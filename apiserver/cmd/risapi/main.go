@@ -88,6 +88,14 @@ func bindFlags(cmd *cobra.Command, v *viper.Viper) {
 		// Apply the viper config value to the flag when the flag is not set and viper has a value
 		if !f.Changed && v.IsSet(f.Name) {
 			val := v.Get(f.Name)
+			// lists from a config file are set as comma separated values
+			if list, ok := val.([]any); ok {
+				items := make([]string, len(list))
+				for i, item := range list {
+					items[i] = fmt.Sprintf("%v", item)
+				}
+				val = strings.Join(items, ",")
+			}
 			cmd.Flags().Set(f.Name, fmt.Sprintf("%v", val))
 		}
 	})
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// prewarm periodically fetches the configured stations so their liveboards and vehicle
+// journeys are in the cache before a screen asks for them, it blocks until ctx is done
+func (s *serveCmdOptions) prewarm(ctx context.Context) {
+	if len(s.Prewarm) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.PrewarmInterval)
+	defer ticker.Stop()
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, s.PrewarmInterval)
+		start := time.Now()
+		// a default board from now is the cache entry every board without a start time is cut from
+		departures, warnings := ris.FetchDepartures(fetchCtx, s.Prewarm, ris.BoardOptions{}, s.MaxConcurrency)
+		cancel()
+
+		log.Printf("prewarmed %d stations with %d departures in %s", len(s.Prewarm), len(departures), time.Since(start))
+		for _, warning := range warnings {
+			log.Printf("prewarm of %s failed: %s", warning.Station, warning.Text)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
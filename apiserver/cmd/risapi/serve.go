@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sort"
+	"io"
+	"log"
 	"strings"
+	"time"
+	"unicode"
 
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ratelimit"
 	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
 	"github.com/meyskens/ris-at-home/apiserver/pkg/ris/delijn"
 	"github.com/meyskens/ris-at-home/apiserver/pkg/ris/irail"
@@ -22,9 +30,51 @@ func init() {
 	rootCmd.AddCommand(NewServeCmd())
 }
 
+// cacheSweepInterval is how often expired entries are removed from the persisted caches
+const cacheSweepInterval = time.Hour
+
 type serveCmdOptions struct {
 	BindAddr string
 	Port     int
+
+	MaxConcurrency     int
+	VehicleConcurrency int
+	RequestTimeout     time.Duration
+
+	IRailRPS           float64
+	IRailBurst         int
+	DeLijnRPS          float64
+	DeLijnBurst        int
+	UpstreamMaxRetries int
+	UpstreamMaxBackoff time.Duration
+
+	IRailBaseURL      string
+	DeLijnBaseURL     string
+	DeLijnKernBaseURL string
+	DeLijnAPIKey      string
+
+	LiveboardCacheTTL time.Duration
+	LiveboardMaxStale time.Duration
+	LiveboardBucket   time.Duration
+	VehicleCacheTTL   time.Duration
+	JourneyCacheTTL   time.Duration
+	StopCacheTTL      time.Duration
+	CacheMaxEntries   int
+	CacheDir          string
+
+	Prewarm         []string
+	PrewarmInterval time.Duration
+
+	StreamInterval time.Duration
+
+	WatchFile     string
+	WatchInterval time.Duration
+
+	irail   *irail.Provider
+	delijn  *delijn.Provider
+	boards  *boardHub
+	watches []watch
+	stores  map[string]*cache.BoltStore
 }
 
 // NewServeCmd generates the `serve` command
@@ -39,16 +89,124 @@ func NewServeCmd() *cobra.Command {
 	}
 	c.Flags().StringVarP(&s.BindAddr, "bind-address", "b", "0.0.0.0", "address to bind port to")
 	c.Flags().IntVarP(&s.Port, "port", "p", 8080, "Port to listen on")
+	c.Flags().IntVar(&s.MaxConcurrency, "max-concurrency", 4, "Maximum number of stations fetched in parallel per request")
+	c.Flags().IntVar(&s.VehicleConcurrency, "vehicle-concurrency", 4, "Maximum number of iRail vehicle lookups in parallel per board")
+	c.Flags().DurationVar(&s.RequestTimeout, "request-timeout", 30*time.Second, "Maximum time to spend fetching a board")
+	c.Flags().Float64Var(&s.IRailRPS, "irail-rps", 3, "Requests per second allowed to the iRail API")
+	c.Flags().IntVar(&s.IRailBurst, "irail-burst", 5, "Burst of requests allowed to the iRail API")
+	c.Flags().Float64Var(&s.DeLijnRPS, "delijn-rps", 5, "Requests per second allowed to the De Lijn API")
+	c.Flags().IntVar(&s.DeLijnBurst, "delijn-burst", 10, "Burst of requests allowed to the De Lijn API")
+	c.Flags().IntVar(&s.UpstreamMaxRetries, "upstream-max-retries", 5, "Maximum retries when an upstream API rate limits us")
+	c.Flags().DurationVar(&s.UpstreamMaxBackoff, "upstream-max-backoff", 30*time.Second, "Maximum wait between retries to a rate limiting upstream API")
+	c.Flags().StringVar(&s.IRailBaseURL, "irail-base-url", irail.API_URL, "Base URL of the iRail API")
+	c.Flags().StringVar(&s.DeLijnBaseURL, "delijn-base-url", delijn.API_URL, "Base URL of the De Lijn travel info API")
+	c.Flags().StringVar(&s.DeLijnKernBaseURL, "delijn-kern-base-url", delijn.KERN_API_URL, "Base URL of the De Lijn kern open data API")
+	c.Flags().StringVar(&s.DeLijnAPIKey, "delijn-api-key", delijn.API_KEY, "De Lijn API subscription key, De Lijn stops are not supported without one")
+	c.Flags().DurationVar(&s.LiveboardCacheTTL, "liveboard-cache-ttl", 5*time.Minute, "How long liveboards are cached")
+	c.Flags().DurationVar(&s.LiveboardMaxStale, "liveboard-max-stale", 5*time.Minute, "How long an expired liveboard may still be served while it is refreshed in the background, 0 to disable")
+	c.Flags().DurationVar(&s.LiveboardBucket, "liveboard-bucket", time.Minute, "Resolution iRail board times are rounded to, requests within the same bucket share a cached board")
+	c.Flags().DurationVar(&s.VehicleCacheTTL, "vehicle-cache-ttl", 48*time.Hour, "How long iRail vehicle journeys are cached for board vias")
+	c.Flags().DurationVar(&s.JourneyCacheTTL, "journey-cache-ttl", time.Minute, "How long iRail vehicle journeys are cached for the realtime journey endpoint")
+	c.Flags().DurationVar(&s.StopCacheTTL, "stop-cache-ttl", 24*time.Hour, "How long De Lijn stop metadata is cached")
+	c.Flags().IntVar(&s.CacheMaxEntries, "cache-max-entries", 10000, "Maximum number of entries per cache, 0 for unbounded")
+	c.Flags().StringSliceVar(&s.Prewarm, "prewarm", nil, "Station IDs to keep warm in the cache by refreshing them in the background")
+	c.Flags().DurationVar(&s.PrewarmInterval, "prewarm-interval", 4*time.Minute, "How often the prewarm stations are refreshed")
+	c.Flags().DurationVar(&s.StreamInterval, "stream-interval", 30*time.Second, "How often boards with stream subscribers are refreshed, bypassing liveboards cached for longer")
+	c.Flags().StringVar(&s.WatchFile, "watch-file", "", "JSON file with departures to send webhook notifications for, empty to disable")
+	c.Flags().DurationVar(&s.WatchInterval, "watch-interval", time.Minute, "How often the watched departures are checked")
+	c.Flags().StringVar(&s.CacheDir, "cache-dir", "", "Directory to persist vehicle and stop caches in, empty to keep them in memory only")
 
 	return c
 }
 
 func (s *serveCmdOptions) Validate(cmd *cobra.Command, args []string) error {
+	if s.MaxConcurrency < 1 {
+		return errors.New("max-concurrency must be at least 1")
+	}
+	if s.VehicleConcurrency < 1 {
+		return errors.New("vehicle-concurrency must be at least 1")
+	}
+	if s.RequestTimeout <= 0 {
+		return errors.New("request-timeout must be positive")
+	}
+	if s.IRailRPS <= 0 || s.DeLijnRPS <= 0 {
+		return errors.New("upstream rps must be positive")
+	}
+	if s.IRailBurst < 1 || s.DeLijnBurst < 1 {
+		return errors.New("upstream burst must be at least 1")
+	}
+	if s.UpstreamMaxRetries < 0 {
+		return errors.New("upstream-max-retries can not be negative")
+	}
+	if s.UpstreamMaxBackoff <= 0 {
+		return errors.New("upstream-max-backoff must be positive")
+	}
+	for name, baseURL := range map[string]string{
+		"irail-base-url":       s.IRailBaseURL,
+		"delijn-base-url":      s.DeLijnBaseURL,
+		"delijn-kern-base-url": s.DeLijnKernBaseURL,
+	} {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return fmt.Errorf("%s is invalid: %w", name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute http(s) URL, got %q", name, baseURL)
+		}
+	}
+	if strings.ContainsFunc(s.DeLijnAPIKey, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		return errors.New("delijn-api-key must only contain letters and digits")
+	}
+	if s.LiveboardCacheTTL <= 0 || s.VehicleCacheTTL <= 0 || s.JourneyCacheTTL <= 0 || s.StopCacheTTL <= 0 {
+		return errors.New("cache TTLs must be positive")
+	}
+	if s.LiveboardMaxStale < 0 {
+		return errors.New("liveboard-max-stale can not be negative")
+	}
+	if s.LiveboardBucket < time.Minute {
+		return errors.New("liveboard-bucket must be at least a minute")
+	}
+	if s.PrewarmInterval <= 0 {
+		return errors.New("prewarm-interval must be positive")
+	}
+	if s.StreamInterval <= 0 {
+		return errors.New("stream-interval must be positive")
+	}
+	if s.WatchInterval <= 0 {
+		return errors.New("watch-interval must be positive")
+	}
+	if s.WatchFile != "" {
+		watches, err := loadWatches(s.WatchFile)
+		if err != nil {
+			return fmt.Errorf("invalid watch-file: %w", err)
+		}
+		s.watches = watches
+	}
+	if s.CacheMaxEntries < 0 {
+		return errors.New("cache-max-entries can not be negative")
+	}
 	return nil
 }
 
 func (s *serveCmdOptions) RunE(cmd *cobra.Command, args []string) error {
+	if err := s.configureUpstreams(); err != nil {
+		return err
+	}
+	cacheDB, err := s.configureCaches()
+	if err != nil {
+		return err
+	}
+	if cacheDB != nil {
+		defer cacheDB.Close()
+	}
+	ris.Register(s.irail)
+	ris.Register(s.delijn)
+	if s.DeLijnAPIKey == "" {
+		log.Println("no De Lijn API key configured, De Lijn stops are not supported, set one with --delijn-api-key or RIS_DELIJN_API_KEY")
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
+	s.boards = newBoardHub(ctx, s.StreamInterval, s.RequestTimeout, s.MaxConcurrency)
 
 	e := echo.New()
 	e.HideBanner = true
@@ -56,42 +214,25 @@ func (s *serveCmdOptions) RunE(cmd *cobra.Command, args []string) error {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
+	// derive every request context from ours so shutdown cancels upstream fetches
+	e.Server.BaseContext = func(net.Listener) context.Context {
+		return ctx
+	}
+
 	// serve static files from public directory
 	e.Static("/", "public")
 
 	// handle API calls
-	e.GET("/db/apis/ris-boards/v1/public/departures/:id", func(c echo.Context) error {
-		stations := strings.Split(c.Param("id"), ",")
-		if stations[0] == "" {
-			stations = []string{"008821006"}
-		}
-		resp := ris.DeparturesResponse{
-			Departures:  []ris.Departure{},
-			Disruptions: []any{},
-		}
-
-		for _, station := range stations {
-			var err error
-			var departures []ris.Departure
-			if strings.HasPrefix(station, "008") {
-				departures, err = irail.LiveboardToRISDepartures(station, "nl")
-			} else {
-				departures, err = delijn.LiveboardToRISDepartures(station)
-			}
-			if err != nil {
-				return c.JSON(http.StatusInternalServerError, err)
-			}
-
-			resp.Departures = append(resp.Departures, departures...)
-		}
-
-		// sort resp.Departures on TimeSchedule
-		sort.Slice(resp.Departures, func(i, j int) bool {
-			return resp.Departures[i].TimeSchedule.Before(resp.Departures[j].TimeSchedule)
-		})
+	e.GET("/db/apis/ris-boards/v1/public/departures/:id", s.handleDepartures)
+	e.GET("/db/apis/ris-boards/v1/public/departures/:id/stream", s.handleDeparturesStream)
+	e.GET("/db/apis/ris-boards/v1/public/subscribe", s.handleBoardsSocket)
+	e.GET("/db/apis/ris-boards/v1/public/arrivals/:id", s.handleArrivals)
+	e.GET("/db/apis/ris-journeys/v1/eventbased/:id", s.handleJourney)
+	e.GET("/debug/cache", s.handleCacheStats)
 
-		return c.JSON(http.StatusOK, resp)
-	})
+	go s.prewarm(ctx)
+	go s.sweepCaches(ctx)
+	go s.watchDepartures(ctx)
 
 	go func() {
 		e.Start(fmt.Sprintf("%s:%d", s.BindAddr, s.Port))
@@ -105,7 +246,202 @@ func (s *serveCmdOptions) RunE(cmd *cobra.Command, args []string) error {
 		case <-c:
 			cancel()
 		case <-ctx.Done():
-			return nil
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			return e.Shutdown(shutdownCtx)
+		}
+	}
+}
+
+// configureUpstreams points the providers at the configured APIs and sets the rate limits of the shared upstream transport
+func (s *serveCmdOptions) configureUpstreams() error {
+	irail.API_URL = strings.TrimSuffix(s.IRailBaseURL, "/")
+	irail.VehicleConcurrency = s.VehicleConcurrency
+	irail.LiveboardBucket = s.LiveboardBucket
+	delijn.API_URL = strings.TrimSuffix(s.DeLijnBaseURL, "/")
+	delijn.KERN_API_URL = strings.TrimSuffix(s.DeLijnKernBaseURL, "/")
+	delijn.API_KEY = s.DeLijnAPIKey
+
+	ratelimit.DefaultTransport.MaxRetries = s.UpstreamMaxRetries
+	ratelimit.DefaultTransport.MaxBackoff = s.UpstreamMaxBackoff
+
+	limits := map[string]ratelimit.Limit{
+		irail.API_URL:       {RPS: s.IRailRPS, Burst: s.IRailBurst},
+		delijn.API_URL:      {RPS: s.DeLijnRPS, Burst: s.DeLijnBurst},
+		delijn.KERN_API_URL: {RPS: s.DeLijnRPS, Burst: s.DeLijnBurst},
+	}
+	for apiURL, limit := range limits {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			return fmt.Errorf("invalid upstream url %q: %w", apiURL, err)
 		}
+		ratelimit.DefaultTransport.SetLimit(u.Host, limit)
 	}
+
+	return nil
+}
+
+// configureCaches creates the providers with caches using the configured TTLs and size,
+// it returns the opened database when the caches are persisted
+func (s *serveCmdOptions) configureCaches() (io.Closer, error) {
+	s.irail = &irail.Provider{
+		Lang:       "nl",
+		Liveboards: cache.New[string, irail.Board](s.LiveboardCacheTTL, s.CacheMaxEntries),
+		Vehicles:   cache.New[string, irail.Vehicle](s.VehicleCacheTTL, s.CacheMaxEntries),
+		Journeys:   cache.New[string, irail.Vehicle](s.JourneyCacheTTL, s.CacheMaxEntries),
+	}
+	s.delijn = &delijn.Provider{
+		Liveboards: cache.New[string, delijn.Liveboard](s.LiveboardCacheTTL, s.CacheMaxEntries),
+		Stops:      cache.New[string, delijn.Stop](s.StopCacheTTL, s.CacheMaxEntries),
+		LineStops:  cache.New[string, []delijn.Stop](s.StopCacheTTL, s.CacheMaxEntries),
+		Patterns:   cache.New[string, []delijn.Stop](s.StopCacheTTL, s.CacheMaxEntries),
+	}
+
+	s.irail.Liveboards.SetMaxStale(s.LiveboardMaxStale)
+	s.delijn.Liveboards.SetMaxStale(s.LiveboardMaxStale)
+
+	if s.CacheDir == "" {
+		return nil, nil
+	}
+
+	db, err := cache.OpenBolt(s.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cache in %s: %w", s.CacheDir, err)
+	}
+	s.stores = map[string]*cache.BoltStore{}
+	stores := map[string]interface{ SetStore(cache.Store) }{
+		"irail-vehicles":    s.irail.Vehicles,
+		"delijn-stops":      s.delijn.Stops,
+		"delijn-line-stops": s.delijn.LineStops,
+		"delijn-patterns":   s.delijn.Patterns,
+	}
+	for bucket, c := range stores {
+		store, err := cache.NewBoltStore(db, bucket)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		if err := store.Sweep(); err != nil {
+			log.Printf("could not clean up cache %s: %v", bucket, err)
+		}
+		c.SetStore(store)
+		s.stores[bucket] = store
+	}
+
+	return db, nil
+}
+
+// sweepCaches removes the expired entries from the persisted caches every cacheSweepInterval,
+// as keys like the vehicle service days are never requested again. It blocks until ctx is done.
+func (s *serveCmdOptions) sweepCaches(ctx context.Context) {
+	if len(s.stores) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cacheSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		for bucket, store := range s.stores {
+			if err := store.Sweep(); err != nil {
+				log.Printf("could not clean up cache %s: %v", bucket, err)
+			}
+		}
+	}
+}
+
+func (s *serveCmdOptions) handleCacheStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]cache.Stats{
+		"irailLiveboards":  s.irail.Liveboards.Stats(),
+		"irailVehicles":    s.irail.Vehicles.Stats(),
+		"irailJourneys":    s.irail.Journeys.Stats(),
+		"delijnLiveboards": s.delijn.Liveboards.Stats(),
+		"delijnStops":      s.delijn.Stops.Stats(),
+		"delijnLineStops":  s.delijn.LineStops.Stats(),
+		"delijnPatterns":   s.delijn.Patterns.Stats(),
+	})
+}
+
+// stationsParam returns the comma separated station IDs in the request, defaulting to Brussels-Central
+func stationsParam(c echo.Context) []string {
+	stations := strings.Split(c.Param("id"), ",")
+	if stations[0] == "" {
+		stations = []string{"008821006"}
+	}
+	return stations
+}
+
+func (s *serveCmdOptions) handleDepartures(c echo.Context) error {
+	stations := stationsParam(c)
+	opts, err := ris.ParseBoardOptions(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), s.RequestTimeout)
+	defer cancel()
+
+	departures, warnings := ris.FetchDepartures(ctx, stations, opts, s.MaxConcurrency)
+	resp := ris.DeparturesResponse{
+		Departures:  departures,
+		Disruptions: ris.CollectDisruptions(departures, func(d ris.Departure) []ris.Disruption { return d.Disruptions }),
+		Warnings:    warnings,
+	}
+
+	// only fail when there is nothing left to show
+	if len(warnings) == len(stations) {
+		return c.JSON(http.StatusBadGateway, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *serveCmdOptions) handleArrivals(c echo.Context) error {
+	stations := stationsParam(c)
+	opts, err := ris.ParseBoardOptions(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), s.RequestTimeout)
+	defer cancel()
+
+	arrivals, warnings := ris.FetchArrivals(ctx, stations, opts, s.MaxConcurrency)
+	resp := ris.ArrivalsResponse{
+		Arrivals:    arrivals,
+		Disruptions: ris.CollectDisruptions(arrivals, func(a ris.Arrival) []ris.Disruption { return a.Disruptions }),
+		Warnings:    warnings,
+	}
+
+	// only fail when there is nothing left to show
+	if len(warnings) == len(stations) {
+		return c.JSON(http.StatusBadGateway, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *serveCmdOptions) handleJourney(c echo.Context) error {
+	provider, id, err := ris.Lookup(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	journeys, ok := provider.(ris.JourneyProvider)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("journeys %s for %s", ris.ErrNotSupported, provider.Name()))
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), s.RequestTimeout)
+	defer cancel()
+
+	journey, err := journeys.Journey(ctx, id)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, journey)
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// streamHeartbeat is how often an idle stream gets a comment, so proxies keep the connection open
+const streamHeartbeat = 30 * time.Second
+
+// handleDeparturesStream sends the departures board as Server-Sent Events, a "departures"
+// event with the full board on connect and "changes" events with what changed after each refresh
+func (s *serveCmdOptions) handleDeparturesStream(c echo.Context) error {
+	stations := stationsParam(c)
+	query := c.QueryParams()
+	if _, err := ris.ParseBoardOptions(query); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	updates, unsubscribe := s.boards.subscribe(stations, query)
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			var err error
+			if update.Changes == nil {
+				err = writeEvent(w, "departures", update.Board)
+			} else {
+				err = writeEvent(w, "changes", map[string]any{"changes": update.Changes})
+			}
+			if err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func writeEvent(w *echo.Response, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
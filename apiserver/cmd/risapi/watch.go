@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+const (
+	// watchLookahead and watchLookbehind bound the part of the day a watch is evaluated in,
+	// around its time window
+	watchLookahead  = 2 * time.Hour
+	watchLookbehind = time.Hour
+
+	// watchBucket is the resolution the start of a watched board is rounded down to, so the
+	// evaluations within a bucket share the cached board
+	watchBucket = 15 * time.Minute
+	// watchMaxResults is the length of the watched boards, a watch is evaluated every interval
+	// and does not need to see far ahead
+	watchMaxResults = 60
+
+	webhookAttempts = 4
+	webhookTimeout  = 10 * time.Second
+	webhookQueue    = 100
+)
+
+// watchFile is the JSON file listing the departures to send webhooks for, eg.
+//
+//	{
+//	  "webhooks": ["https://example.com/hook"],
+//	  "watches": [{
+//	    "name": "IC to Ghent", "station": "008821006", "category": "IC", "number": 1234,
+//	    "timeStart": "17:30", "timeEnd": "17:50", "delayMinutes": 5, "platformChange": true
+//	  }]
+//	}
+type watchFile struct {
+	Webhooks []string `json:"webhooks"`
+	Watches  []watch  `json:"watches"`
+}
+
+// watch describes departures to notify about, empty fields match every departure
+type watch struct {
+	Name     string `json:"name"`
+	Station  string `json:"station"`
+	Line     string `json:"line"`
+	Category string `json:"category"`
+	Number   int    `json:"number"`
+
+	// TimeStart and TimeEnd are the scheduled departure times of the day (15:04) to watch,
+	// a window ending before it starts crosses midnight
+	TimeStart string `json:"timeStart"`
+	TimeEnd   string `json:"timeEnd"`
+
+	// DelayMinutes notifies when a departure is at least this late, and again for every
+	// further DelayMinutes, 0 disables delay notifications
+	DelayMinutes   int  `json:"delayMinutes"`
+	PlatformChange bool `json:"platformChange"`
+	Canceled       bool `json:"canceled"`
+
+	// Webhooks overrides the webhooks of the watch file
+	Webhooks []string `json:"webhooks"`
+}
+
+// webhookPayload is POSTed to the webhooks of a watch
+type webhookPayload struct {
+	Watch  string              `json:"watch"`
+	Text   string              `json:"text"`
+	Change ris.DepartureChange `json:"change"`
+}
+
+type webhookCall struct {
+	url     string
+	payload webhookPayload
+}
+
+// loadWatches reads and validates the watch file
+func loadWatches(path string) ([]watch, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file watchFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i, w := range file.Watches {
+		if w.Name == "" {
+			w.Name = fmt.Sprintf("watch %d", i+1)
+		}
+		// the notifications sent are remembered by name
+		if names[w.Name] {
+			return nil, fmt.Errorf("%s: duplicate watch name", w.Name)
+		}
+		names[w.Name] = true
+		if w.Station == "" {
+			return nil, fmt.Errorf("%s: station is required", w.Name)
+		}
+		if len(w.Webhooks) == 0 {
+			w.Webhooks = file.Webhooks
+		}
+		if len(w.Webhooks) == 0 {
+			return nil, fmt.Errorf("%s: no webhooks configured", w.Name)
+		}
+		if w.DelayMinutes < 0 {
+			return nil, fmt.Errorf("%s: delayMinutes can not be negative", w.Name)
+		}
+		if w.DelayMinutes == 0 && !w.PlatformChange && !w.Canceled {
+			return nil, fmt.Errorf("%s: nothing to notify about", w.Name)
+		}
+		for _, t := range []string{w.TimeStart, w.TimeEnd} {
+			if _, err := time.Parse("15:04", t); t != "" && err != nil {
+				return nil, fmt.Errorf("%s: invalid time %q, expected HH:MM", w.Name, t)
+			}
+		}
+		if w.TimeStart != "" && w.TimeStart == w.TimeEnd {
+			return nil, fmt.Errorf("%s: timeStart and timeEnd are the same", w.Name)
+		}
+		file.Watches[i] = w
+	}
+
+	return file.Watches, nil
+}
+
+// window returns the time window of the watch on the day of now. For a window crossing
+// midnight this is the one that started yesterday until a while after it ended.
+func (w watch) window(now time.Time) (time.Time, time.Time) {
+	tz, _ := time.LoadLocation("Europe/Brussels")
+	now = now.In(tz)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, tz)
+
+	start, end := day, day.AddDate(0, 0, 1)
+	if t, err := time.Parse("15:04", w.TimeStart); err == nil {
+		start = time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, tz)
+	}
+	if t, err := time.Parse("15:04", w.TimeEnd); err == nil {
+		end = time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, tz)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+			if yesterday := end.AddDate(0, 0, -1); now.Before(yesterday.Add(watchLookbehind)) {
+				return start.AddDate(0, 0, -1), yesterday
+			}
+		}
+	}
+	return start, end
+}
+
+func (w watch) matches(d ris.Departure) bool {
+	if w.Category != "" && !strings.EqualFold(w.Category, d.Transport.Category) {
+		return false
+	}
+	if w.Number != 0 && w.Number != d.Transport.Number {
+		return false
+	}
+	if w.Line != "" && (d.Transport.Line == nil || !strings.EqualFold(w.Line, *d.Transport.Line)) {
+		return false
+	}
+	return true
+}
+
+// watchDepartures evaluates the watches every WatchInterval and calls their webhooks, it
+// blocks until ctx is done
+func (s *serveCmdOptions) watchDepartures(ctx context.Context) {
+	if len(s.watches) == 0 {
+		return
+	}
+
+	// notified remembers what was sent per watch and departure so every change is sent once
+	notified := cache.New[string, int](24*time.Hour, 10000)
+	calls := make(chan webhookCall, webhookQueue)
+	go sendWebhooks(ctx, calls)
+
+	ticker := time.NewTicker(s.WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, w := range s.watches {
+			for _, change := range s.evaluateWatch(ctx, w, notified) {
+				payload := webhookPayload{Watch: w.Name, Text: change.String(), Change: change}
+				for _, url := range w.Webhooks {
+					select {
+					case calls <- webhookCall{url: url, payload: payload}:
+					default:
+						log.Printf("webhook queue full, dropping %q for %s", payload.Text, url)
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// evaluateWatch returns the changes of the watched departures that were not notified yet
+func (s *serveCmdOptions) evaluateWatch(ctx context.Context, w watch, notified *cache.Cache[string, int]) []ris.DepartureChange {
+	now := time.Now()
+	start, end := w.window(now)
+	if now.Before(start.Add(-watchLookahead)) || now.After(end.Add(watchLookbehind)) {
+		return nil
+	}
+
+	// departures that left long ago can not change anymore, don't spend the board on them
+	from := start
+	if earliest := now.Add(-watchLookbehind).Truncate(watchBucket); from.Before(earliest) {
+		from = earliest
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, s.RequestTimeout)
+	defer cancel()
+	departures, warnings := ris.FetchDepartures(fetchCtx, []string{w.Station}, ris.BoardOptions{
+		MaxResults: watchMaxResults,
+		TimeStart:  from,
+		TimeEnd:    end,
+	}, s.MaxConcurrency)
+	for _, warning := range warnings {
+		log.Printf("%s: could not fetch %s: %s", w.Name, warning.Station, warning.Text)
+	}
+
+	var changes []ris.DepartureChange
+	for _, d := range departures {
+		if !w.matches(d) || d.TimeSchedule.Before(start) || d.TimeSchedule.After(end) {
+			continue
+		}
+		key := w.Name + "|" + d.DepartureID + "|"
+
+		if delay := int(ris.Delay(d).Minutes()); w.DelayMinutes > 0 && delay >= w.DelayMinutes {
+			last, ok := notified.Get(key + "delay")
+			if !ok || delay >= last+w.DelayMinutes {
+				notified.Set(key+"delay", delay)
+				changes = append(changes, ris.DepartureChange{
+					Type:          ris.ChangeDelayIncreased,
+					DepartureID:   d.DepartureID,
+					Departure:     d,
+					Delay:         int(ris.Delay(d).Seconds()),
+					PreviousDelay: last * 60,
+				})
+			}
+		}
+		if w.PlatformChange && ris.PlatformChanged(d) {
+			if _, ok := notified.Get(key + "platform|" + d.Platform); !ok {
+				notified.Set(key+"platform|"+d.Platform, 1)
+				change := ris.DepartureChange{
+					Type:        ris.ChangePlatformChanged,
+					DepartureID: d.DepartureID,
+					Departure:   d,
+					Platform:    d.Platform,
+				}
+				// iRail only tells a train moved, with a scheduled platform of 0
+				if d.PlatformSchedule != "0" {
+					change.PreviousPlatform = d.PlatformSchedule
+				}
+				changes = append(changes, change)
+			}
+		}
+		if w.Canceled && d.Canceled {
+			if _, ok := notified.Get(key + "canceled"); !ok {
+				notified.Set(key+"canceled", 1)
+				changes = append(changes, ris.DepartureChange{Type: ris.ChangeCanceled, DepartureID: d.DepartureID, Departure: d})
+			}
+		}
+	}
+
+	return changes
+}
+
+// sendWebhooks POSTs the queued webhook calls, retrying failed calls with a growing backoff
+func sendWebhooks(ctx context.Context, calls <-chan webhookCall) {
+	client := &http.Client{Timeout: webhookTimeout}
+	for {
+		select {
+		case call := <-calls:
+			if err := postWebhook(ctx, client, call); err != nil {
+				log.Printf("webhook %s for %q failed: %v", call.url, call.payload.Text, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, client *http.Client, call webhookCall) error {
+	body, err := json.Marshal(call.payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = doWebhook(ctx, client, call.url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func doWebhook(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
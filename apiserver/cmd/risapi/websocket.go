@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+	"golang.org/x/net/websocket"
+)
+
+// maxSocketSubscriptions is the number of boards one WebSocket connection may watch
+const maxSocketSubscriptions = 32
+
+// socketRequest is a message from a WebSocket client, eg.
+// {"action":"subscribe","id":"left","stations":["008821006"],"query":"maxResults=10"}
+type socketRequest struct {
+	Action   string   `json:"action"`
+	ID       string   `json:"id"`
+	Stations []string `json:"stations"`
+	Query    string   `json:"query"`
+}
+
+// socketMessage is a message to a WebSocket client, either a board for a subscription or an error
+type socketMessage struct {
+	Type  string                  `json:"type"`
+	ID    string                  `json:"id,omitempty"`
+	Board *ris.DeparturesResponse `json:"board,omitempty"`
+	Error string                  `json:"error,omitempty"`
+}
+
+// handleBoardsSocket lets a client subscribe to multiple departure boards over one WebSocket,
+// every subscription gets the full board on subscribe and again whenever it changes
+func (s *serveCmdOptions) handleBoardsSocket(c echo.Context) error {
+	server := websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			s.serveBoardsSocket(c.Request().Context(), ws)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+func (s *serveCmdOptions) serveBoardsSocket(ctx context.Context, ws *websocket.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// a hijacked connection is not closed on shutdown, unblock Receive ourselves
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	subscriptions := map[string]context.CancelFunc{}
+	send := func(msg socketMessage) {
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			cancel()
+		}
+	}
+
+	for {
+		var req socketRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+
+		switch req.Action {
+		case "subscribe":
+			if req.ID == "" || len(req.Stations) == 0 {
+				send(socketMessage{Type: "error", ID: req.ID, Error: "subscribe needs an id and stations"})
+				continue
+			}
+			if _, ok := subscriptions[req.ID]; !ok && len(subscriptions) >= maxSocketSubscriptions {
+				send(socketMessage{Type: "error", ID: req.ID, Error: fmt.Sprintf("at most %d subscriptions are allowed", maxSocketSubscriptions)})
+				continue
+			}
+			query, err := url.ParseQuery(req.Query)
+			if err == nil {
+				_, err = ris.ParseBoardOptions(query)
+			}
+			if err != nil {
+				send(socketMessage{Type: "error", ID: req.ID, Error: err.Error()})
+				continue
+			}
+
+			if stop, ok := subscriptions[req.ID]; ok {
+				stop()
+			}
+			subCtx, stop := context.WithCancel(ctx)
+			subscriptions[req.ID] = stop
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.forwardBoard(subCtx, req.ID, req.Stations, query, send)
+			}()
+		case "unsubscribe":
+			if stop, ok := subscriptions[req.ID]; ok {
+				stop()
+				delete(subscriptions, req.ID)
+			}
+		default:
+			send(socketMessage{Type: "error", ID: req.ID, Error: fmt.Sprintf("unknown action %q", req.Action)})
+		}
+	}
+}
+
+// forwardBoard sends every update of a board to the client until ctx is done
+func (s *serveCmdOptions) forwardBoard(ctx context.Context, id string, stations []string, query url.Values, send func(socketMessage)) {
+	for ctx.Err() == nil {
+		updates, unsubscribe := s.boards.subscribe(stations, query)
+		for open := true; open; {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					// the hub dropped us, subscribe again to get a full board
+					open = false
+					continue
+				}
+				send(socketMessage{Type: "departures", ID: id, Board: &update.Board})
+			case <-ctx.Done():
+				open = false
+			}
+		}
+		unsubscribe()
+	}
+}
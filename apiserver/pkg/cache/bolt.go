@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is a persistent second level behind a Cache, so entries survive restarts
+type Store interface {
+	// Get returns the stored value and its expiry time
+	Get(key string) ([]byte, time.Time, bool, error)
+	Put(key string, value []byte, expires time.Time) error
+}
+
+// OpenBolt opens (or creates) the cache database in dir
+func OpenBolt(dir string) (*bolt.DB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return bolt.Open(filepath.Join(dir, "cache.db"), 0o600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// BoltStore stores entries in a single bucket of a bolt database,
+// every value is prefixed with its expiry time in unix nanoseconds
+type BoltStore struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+func NewBoltStore(db *bolt.DB, bucket string) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltStore{db: db, bucket: []byte(bucket)}, nil
+}
+
+func (b *BoltStore) Get(key string) ([]byte, time.Time, bool, error) {
+	var value []byte
+	var expires time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(b.bucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		if len(data) < 8 {
+			return errors.New("cache: corrupt entry")
+		}
+		expires = time.Unix(0, int64(binary.BigEndian.Uint64(data[:8])))
+		// data is only valid inside the transaction
+		value = append([]byte{}, data[8:]...)
+		return nil
+	})
+	if err != nil || value == nil {
+		return nil, time.Time{}, false, err
+	}
+
+	return value, expires, true, nil
+}
+
+func (b *BoltStore) Put(key string, value []byte, expires time.Time) error {
+	data := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(data[:8], uint64(expires.UnixNano()))
+	copy(data[8:], value)
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(key), data)
+	})
+}
+
+// Sweep removes all expired entries
+func (b *BoltStore) Sweep() error {
+	now := time.Now()
+	return b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(b.bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) < 8 || now.After(time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// refreshTimeout bounds background refreshes of stale entries
+const refreshTimeout = 2 * time.Minute
+
+// Cache is an in-memory key value cache where every entry expires after its TTL.
+// When MaxSize entries are stored the least recently used entry is evicted.
+// Expired entries are kept for up to maxStale so Fetch can serve them while refreshing.
+// An optional Store persists entries as JSON behind the in-memory cache.
+type Cache[K comparable, V any] struct {
+	ttl      time.Duration
+	maxSize  int
+	maxStale time.Duration
+
+	mutex sync.Mutex
+	items map[K]*list.Element
+	lru   *list.List // front is most recently used
+
+	loads Group[K, V]
+	store Store
+
+	hits      atomic.Uint64
+	staleHits atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	stored     time.Time
+	expires    time.Time
+	staleUntil time.Time
+}
+
+// Stats are the counters of a cache since it was created
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	StaleHits uint64 `json:"staleHits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+// New creates a cache with a default TTL for entries, a maxSize of 0 means unbounded
+func New[K comparable, V any](ttl time.Duration, maxSize int) *Cache[K, V] {
+	return &Cache[K, V]{
+		ttl:     ttl,
+		maxSize: maxSize,
+		items:   map[K]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// SetMaxStale sets how long after expiring an entry may still be served by Fetch
+func (c *Cache[K, V]) SetMaxStale(maxStale time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.maxStale = maxStale
+}
+
+// SetStore sets the persistent store entries are written through to and read from on a miss
+func (c *Cache[K, V]) SetStore(store Store) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.store = store
+}
+
+// Get returns the value for key if it is present and not expired
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	e, ok := c.get(key)
+	if !ok {
+		e, ok = c.fromStore(key)
+	}
+	if !ok || time.Now().After(e.expires) {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+
+	c.hits.Add(1)
+	return e.value, true
+}
+
+// Fetch returns the value for key, calling load on a miss. Concurrent loads of the same
+// key share one call. A value that expired less than maxStale ago is returned right away
+// while it gets refreshed in the background.
+func (c *Cache[K, V]) Fetch(ctx context.Context, key K, load func(ctx context.Context) (V, error)) (V, error) {
+	return c.FetchMaxAge(ctx, key, 0, load)
+}
+
+// FetchMaxAge is Fetch for callers that need a value stored at most maxAge ago, older values
+// are loaded again before returning. A maxAge of 0 accepts every value Fetch would return.
+func (c *Cache[K, V]) FetchMaxAge(ctx context.Context, key K, maxAge time.Duration, load func(ctx context.Context) (V, error)) (V, error) {
+	tooOld := func(e entry[K, V]) bool {
+		return maxAge > 0 && time.Since(e.stored) > maxAge
+	}
+
+	e, ok := c.get(key)
+	if ok && !tooOld(e) && !time.Now().After(e.expires) {
+		c.hits.Add(1)
+		return e.value, nil
+	}
+
+	if ok && !tooOld(e) {
+		c.staleHits.Add(1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+			defer cancel()
+			if _, err := c.load(ctx, key, load); err != nil {
+				log.Printf("cache: background refresh failed: %v", err)
+			}
+		}()
+		return e.value, nil
+	}
+
+	if e, ok := c.fromStore(key); ok && !tooOld(e) {
+		c.hits.Add(1)
+		return e.value, nil
+	}
+
+	c.misses.Add(1)
+	return c.load(ctx, key, load)
+}
+
+// Refresh loads key right away and stores it, for callers that found the cached value unusable.
+// It shares the load with a concurrent Fetch of the same key and is counted as a miss.
+func (c *Cache[K, V]) Refresh(ctx context.Context, key K, load func(ctx context.Context) (V, error)) (V, error) {
+	c.misses.Add(1)
+	return c.load(ctx, key, load)
+}
+
+func (c *Cache[K, V]) load(ctx context.Context, key K, load func(ctx context.Context) (V, error)) (V, error) {
+	return c.loads.Do(ctx, key, func(ctx context.Context) (V, error) {
+		value, err := load(ctx)
+		if err != nil {
+			return value, err
+		}
+		c.Set(key, value)
+		return value, nil
+	})
+}
+
+// get returns a copy of the entry for key, dropping it when it is too stale
+func (c *Cache[K, V]) get(key K) (entry[K, V], bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return entry[K, V]{}, false
+	}
+
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.staleUntil) {
+		c.removeElement(el)
+		return entry[K, V]{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	return *e, true
+}
+
+// fromStore loads key from the persistent store into memory
+func (c *Cache[K, V]) fromStore(key K) (entry[K, V], bool) {
+	c.mutex.Lock()
+	store := c.store
+	c.mutex.Unlock()
+	if store == nil {
+		return entry[K, V]{}, false
+	}
+
+	data, expires, ok, err := store.Get(fmt.Sprint(key))
+	if err != nil {
+		log.Printf("cache: could not read %v from store: %v", key, err)
+		return entry[K, V]{}, false
+	}
+	if !ok || time.Now().After(expires) {
+		return entry[K, V]{}, false
+	}
+
+	var value V
+	if err := json.Unmarshal(data, &value); err != nil {
+		log.Printf("cache: could not decode %v from store: %v", key, err)
+		return entry[K, V]{}, false
+	}
+
+	// the store only knows the expiry, assume the entry got the default TTL
+	stored := expires.Add(-c.ttl)
+	c.mutex.Lock()
+	c.setLocked(key, value, stored, expires)
+	c.mutex.Unlock()
+
+	return entry[K, V]{key: key, value: value, stored: stored, expires: expires}, true
+}
+
+// Set stores value for key with the default TTL
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores value for key, expiring after ttl
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	stored := time.Now()
+	expires := stored.Add(ttl)
+
+	c.mutex.Lock()
+	c.setLocked(key, value, stored, expires)
+	store := c.store
+	c.mutex.Unlock()
+
+	if store == nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = store.Put(fmt.Sprint(key), data, expires)
+	}
+	if err != nil {
+		log.Printf("cache: could not persist %v: %v", key, err)
+	}
+}
+
+func (c *Cache[K, V]) setLocked(key K, value V, stored, expires time.Time) {
+	staleUntil := expires.Add(c.maxStale)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value = value
+		e.stored = stored
+		e.expires = expires
+		e.staleUntil = staleUntil
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&entry[K, V]{key: key, value: value, stored: stored, expires: expires, staleUntil: staleUntil})
+
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Delete removes key from the cache
+func (c *Cache[K, V]) Delete(key K) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Len returns the number of stored entries, including expired ones not yet removed
+func (c *Cache[K, V]) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:      c.hits.Load(),
+		StaleHits: c.staleHits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      c.Len(),
+	}
+}
+
+func (c *Cache[K, V]) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCacheExpiresAfterTTL(t *testing.T) {
+	c := New[string, int](20*time.Millisecond, 0)
+	c.Set("a", 1)
+
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get before the TTL = %d, %v, want 1, true", v, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, ok := c.Get("a"); ok {
+		t.Errorf("Get after the TTL = %d, true, want a miss", v)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string, int](time.Hour, 2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a") // a is now more recently used than b
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b is still cached, want it evicted as the least recently used entry")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted, want it cached", key)
+		}
+	}
+	if got := c.Stats().Evictions; got != 1 {
+		t.Errorf("got %d evictions, want 1", got)
+	}
+}
+
+func TestCacheFetchServesStale(t *testing.T) {
+	c := New[string, string](20*time.Millisecond, 0)
+	c.SetMaxStale(time.Hour)
+
+	if _, err := c.Fetch(context.Background(), "a", func(context.Context) (string, error) { return "old", nil }); err != nil {
+		t.Fatalf("first Fetch: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	release := make(chan struct{})
+	refreshed := make(chan struct{})
+	v, err := c.Fetch(context.Background(), "a", func(context.Context) (string, error) {
+		<-release
+		defer close(refreshed)
+		return "new", nil
+	})
+	if err != nil || v != "old" {
+		t.Fatalf("stale Fetch = %q, %v, want the stale value without waiting for the refresh", v, err)
+	}
+
+	close(release)
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("the stale entry was not refreshed in the background")
+	}
+	// the refreshed value is stored right after load returns
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := c.Get("a"); ok && v == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the refreshed value was not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheFetchDropsTooStale(t *testing.T) {
+	c := New[string, string](10*time.Millisecond, 0)
+	c.SetMaxStale(10 * time.Millisecond)
+	c.Set("a", "old")
+	time.Sleep(30 * time.Millisecond)
+
+	v, err := c.Fetch(context.Background(), "a", func(context.Context) (string, error) { return "new", nil })
+	if err != nil || v != "new" {
+		t.Errorf("Fetch past maxStale = %q, %v, want the freshly loaded value", v, err)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := New[string, int](20*time.Millisecond, 0)
+	c.SetMaxStale(time.Hour)
+	load := func(context.Context) (int, error) { return 1, nil }
+
+	c.Fetch(context.Background(), "a", load) // miss
+	c.Fetch(context.Background(), "a", load) // hit
+	c.Get("b")                               // miss
+	time.Sleep(30 * time.Millisecond)
+	c.Fetch(context.Background(), "a", load) // stale hit
+
+	got := c.Stats()
+	want := Stats{Hits: 1, StaleHits: 1, Misses: 2, Size: 1}
+	if got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheFetchMaxAgeReloadsOlderValues(t *testing.T) {
+	c := New[string, string](time.Hour, 0)
+	c.Set("a", "old")
+	time.Sleep(20 * time.Millisecond)
+
+	v, err := c.FetchMaxAge(context.Background(), "a", time.Hour, func(context.Context) (string, error) { return "new", nil })
+	if err != nil || v != "old" {
+		t.Errorf("FetchMaxAge within the max age = %q, %v, want the cached value", v, err)
+	}
+	v, err = c.FetchMaxAge(context.Background(), "a", 10*time.Millisecond, func(context.Context) (string, error) { return "new", nil })
+	if err != nil || v != "new" {
+		t.Errorf("FetchMaxAge past the max age = %q, %v, want the freshly loaded value", v, err)
+	}
+}
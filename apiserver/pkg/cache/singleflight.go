@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// Group deduplicates concurrent calls for the same key, so callers asking for the
+// same upstream resource at the same time share a single fetch
+type Group[K comparable, V any] struct {
+	mutex sync.Mutex
+	calls map[K]*call[V]
+}
+
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do calls fn once for all concurrent callers with the same key and returns its result.
+// fn gets a context that is only cancelled once every waiting caller's ctx is done, so
+// one impatient caller does not fail the fetch for the others.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = map[K]*call[V]{}
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+
+		go func() {
+			c.value, c.err = fn(callCtx)
+			cancel()
+
+			g.mutex.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mutex.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err
+	case <-ctx.Done():
+		g.mutex.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody wants the result anymore, later callers start a new fetch
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mutex.Unlock()
+
+		var zero V
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until n callers wait for the call of key
+func waitForWaiters(t *testing.T, g *Group[string, int], key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		g.mutex.Lock()
+		c, ok := g.calls[key]
+		waiters := 0
+		if ok {
+			waiters = c.waiters
+		}
+		g.mutex.Unlock()
+		if waiters == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d waiters for %s, want %d", waiters, key, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGroupSharesCall(t *testing.T) {
+	var g Group[string, int]
+	var calls int32
+	release := make(chan struct{})
+	fn := func(context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make([]int, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = g.Do(context.Background(), "a", fn)
+		}()
+	}
+	waitForWaiters(t, &g, "a", callers)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fn was called %d times, want 1", got)
+	}
+	for i, v := range results {
+		if v != 42 {
+			t.Errorf("caller %d got %d, want 42", i, v)
+		}
+	}
+}
+
+func TestGroupCallerGivingUpKeepsCall(t *testing.T) {
+	var g Group[string, int]
+	release := make(chan struct{})
+	fnCanceled := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			close(fnCanceled)
+			return 0, ctx.Err()
+		}
+	}
+
+	impatient, cancel := context.WithCancel(context.Background())
+	impatientErr := make(chan error)
+	go func() {
+		_, err := g.Do(impatient, "a", fn)
+		impatientErr <- err
+	}()
+	waitForWaiters(t, &g, "a", 1)
+
+	patient := make(chan int)
+	go func() {
+		v, _ := g.Do(context.Background(), "a", fn)
+		patient <- v
+	}()
+	waitForWaiters(t, &g, "a", 2)
+
+	cancel()
+	if err := <-impatientErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("impatient caller got %v, want %v", err, context.Canceled)
+	}
+	waitForWaiters(t, &g, "a", 1)
+
+	close(release)
+	if v := <-patient; v != 42 {
+		t.Errorf("patient caller got %d, want 42", v)
+	}
+	select {
+	case <-fnCanceled:
+		t.Error("fn was cancelled while a caller still waited for it")
+	default:
+	}
+}
+
+func TestGroupLastCallerGivingUpCancelsCall(t *testing.T) {
+	var g Group[string, int]
+	var calls int32
+	fnCanceled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := g.Do(ctx, "a", func(ctx context.Context) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-ctx.Done()
+			close(fnCanceled)
+			return 0, ctx.Err()
+		})
+		done <- err
+	}()
+	waitForWaiters(t, &g, "a", 1)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("caller got %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-fnCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("fn was not cancelled after its last caller gave up")
+	}
+
+	v, err := g.Do(context.Background(), "a", func(context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 42, nil
+	})
+	if err != nil || v != 42 {
+		t.Errorf("next caller got %d, %v, want 42 from a fresh call", v, err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("fn was called %d times, want 2", got)
+	}
+}
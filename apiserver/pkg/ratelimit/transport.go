@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultTransport is the transport shared by all upstream API clients
+var DefaultTransport = NewTransport(http.DefaultTransport)
+
+// Limit is the request budget for a single upstream host
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+// Transport is a http.RoundTripper that rate limits requests per host and
+// retries with exponential backoff when the upstream answers 429
+type Transport struct {
+	Base http.RoundTripper
+
+	MaxRetries  int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewClient returns a http.Client using DefaultTransport
+func NewClient() *http.Client {
+	return &http.Client{Transport: DefaultTransport}
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:        base,
+		MaxRetries:  5,
+		BaseBackoff: 1 * time.Second,
+		MaxBackoff:  30 * time.Second,
+		limiters:    map[string]*rate.Limiter{},
+	}
+}
+
+// SetLimit sets the budget for the given host, hosts without a limit are not rate limited
+func (t *Transport) SetLimit(host string, l Limit) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.limiters[host] = rate.NewLimiter(rate.Limit(l.RPS), l.Burst)
+}
+
+func (t *Transport) limiter(host string) *rate.Limiter {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.limiters[host]
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	limiter := t.limiter(req.URL.Host)
+
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, http.ErrBodyNotAllowed
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := t.Base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= t.MaxRetries {
+			return resp, nil
+		}
+
+		// retrying before the upstream asked us to is not allowed, hand back the 429 instead
+		wait, ok := t.backoff(attempt, resp.Header.Get("Retry-After"))
+		if deadline, hasDeadline := ctx.Deadline(); !ok || (hasDeadline && time.Now().Add(wait).After(deadline)) {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		log.Printf("Rate limited by %s, waiting %s", req.URL.Host, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns the time to wait before the next attempt, preferring the
+// upstream's Retry-After header when it is set. It returns false when the
+// upstream asks us to wait longer than MaxBackoff.
+func (t *Transport) backoff(attempt int, retryAfter string) (time.Duration, bool) {
+	wait := t.MaxBackoff
+	if attempt < 16 {
+		wait = t.BaseBackoff << attempt
+	}
+	if wait > t.MaxBackoff {
+		wait = t.MaxBackoff
+	}
+
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(retryAfter); err == nil {
+		wait = time.Until(date)
+	} else {
+		return wait, true
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, wait <= t.MaxBackoff
+}
@@ -3,8 +3,24 @@ package ris
 import "time"
 
 type DeparturesResponse struct {
-	Departures  []Departure `json:"departures"`
-	Disruptions []any       `json:"disruptions"`
+	Departures  []Departure  `json:"departures"`
+	Disruptions []Disruption `json:"disruptions"`
+	Warnings    []Warning    `json:"warnings,omitempty"`
+}
+
+const (
+	WarningUnknownStation = "UNKNOWN_STATION"
+	WarningUpstreamError  = "UPSTREAM_ERROR"
+	WarningTimeout        = "TIMEOUT"
+	WarningNotSupported   = "NOT_SUPPORTED"
+)
+
+// Warning describes a station that could not be included in a response
+type Warning struct {
+	Station  string `json:"station"`
+	Provider string `json:"provider,omitempty"`
+	Code     string `json:"code"`
+	Text     string `json:"text"`
 }
 
 type Station struct {
@@ -13,17 +29,60 @@ type Station struct {
 }
 
 type Transport struct {
-	Type                 string      `json:"type"`
-	Category             string      `json:"category"`
-	Number               int         `json:"number"`
-	Line                 any         `json:"line"`
-	Label                string      `json:"label"`
-	ReplacementTransport any         `json:"replacementTransport"`
-	Direction            Direction   `json:"direction"`
-	JourneyID            string      `json:"journeyID"`
-	Destination          Destination `json:"destination"`
-	DifferingDestination any         `json:"differingDestination"`
-	Via                  []Via       `json:"via"`
+	Type                 string                `json:"type"`
+	Category             string                `json:"category"`
+	Number               int                   `json:"number"`
+	Line                 *string               `json:"line"`
+	LineDetails          *LineDetails          `json:"lineDetails,omitempty"`
+	Label                string                `json:"label"`
+	ReplacementTransport *ReplacementTransport `json:"replacementTransport"`
+	Direction            Direction             `json:"direction"`
+	JourneyID            string                `json:"journeyID"`
+	Destination          Destination           `json:"destination"`
+	DifferingDestination *Destination          `json:"differingDestination"`
+	Via                  []Via                 `json:"via"`
+}
+
+// LineDetails carries the styling of a line badge for operators that use coloured lines
+type LineDetails struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Color       LineColor `json:"color"`
+}
+
+type LineColor struct {
+	Foreground       string `json:"foreground"`
+	ForegroundBorder string `json:"foregroundBorder"`
+	Background       string `json:"background"`
+	BackgroundBorder string `json:"backgroundBorder"`
+}
+
+// ReplacementTransport is set when a transport runs with another vehicle type than planned,
+// eg. a bus replacing a train
+type ReplacementTransport struct {
+	RealType string `json:"realType"`
+}
+
+// TransportRef references another transport, eg. the train a bus replaces
+type TransportRef struct {
+	Type      string  `json:"type"`
+	Category  string  `json:"category"`
+	Number    int     `json:"number"`
+	Line      *string `json:"line"`
+	Label     string  `json:"label"`
+	JourneyID string  `json:"journeyID"`
+}
+
+// TravelsWith is a transport coupled to this one, that is split off or joined along the way
+type TravelsWith struct {
+	TransportRef
+	SeparationAt *StopPlace `json:"separationAt"`
+	JoinedAt     *StopPlace `json:"joinedAt"`
+}
+
+type Codeshare struct {
+	AirlineCode  string `json:"airlineCode"`
+	Flightnumber string `json:"flightnumber"`
 }
 
 type StopPlace struct {
@@ -65,6 +124,24 @@ type Message struct {
 	TextShort       any    `json:"textShort"`
 }
 
+const (
+	DisruptionTypeIncident     = "INCIDENT"
+	DisruptionTypeStrike       = "STRIKE"
+	DisruptionTypeConstruction = "CONSTRUCTION"
+)
+
+// Disruption is a service alert affecting a departure or a whole board
+type Disruption struct {
+	DisruptionID    string     `json:"disruptionID"`
+	Type            string     `json:"type"`
+	DisplayPriority int        `json:"displayPriority"`
+	Text            string     `json:"text"`
+	TextShort       string     `json:"textShort"`
+	Link            string     `json:"link,omitempty"`
+	TimeStart       *time.Time `json:"timeStart,omitempty"`
+	TimeEnd         *time.Time `json:"timeEnd,omitempty"`
+}
+
 type Attribute struct {
 	DisplayPriority       any    `json:"displayPriority"`
 	DisplayPriorityDetail any    `json:"displayPriorityDetail"`
@@ -83,19 +160,102 @@ type Departure struct {
 	Platform          string         `json:"platform"`
 	Administration    Administration `json:"administration"`
 	Messages          []Message      `json:"messages"`
-	Disruptions       []any          `json:"disruptions"`
+	Disruptions       []Disruption   `json:"disruptions"`
 	Attributes        []Attribute    `json:"attributes"`
 	DepartureID       string         `json:"departureID"`
 	Transport         Transport      `json:"transport"`
 	JourneyType       string         `json:"journeyType"`
 	Additional        bool           `json:"additional"`
 	Canceled          bool           `json:"canceled"`
-	ReliefFor         []any          `json:"reliefFor"`
-	ReliefBy          []any          `json:"reliefBy"`
-	ReplacementFor    []any          `json:"replacementFor"`
-	ReplacedBy        []any          `json:"replacedBy"`
-	ContinuationBy    any            `json:"continuationBy"`
-	TravelsWith       []any          `json:"travelsWith"`
-	Codeshares        []any          `json:"codeshares"`
+	ReliefFor         []TransportRef `json:"reliefFor"`
+	ReliefBy          []TransportRef `json:"reliefBy"`
+	ReplacementFor    []TransportRef `json:"replacementFor"`
+	ReplacedBy        []TransportRef `json:"replacedBy"`
+	ContinuationBy    *TransportRef  `json:"continuationBy"`
+	TravelsWith       []TravelsWith  `json:"travelsWith"`
+	Codeshares        []Codeshare    `json:"codeshares"`
 	FutureDisruptions bool           `json:"futureDisruptions"`
 }
+
+type ArrivalsResponse struct {
+	Arrivals    []Arrival    `json:"arrivals"`
+	Disruptions []Disruption `json:"disruptions"`
+	Warnings    []Warning    `json:"warnings,omitempty"`
+}
+
+type ArrivalTransport struct {
+	Type                 string                `json:"type"`
+	Category             string                `json:"category"`
+	Number               int                   `json:"number"`
+	Line                 *string               `json:"line"`
+	LineDetails          *LineDetails          `json:"lineDetails,omitempty"`
+	Label                string                `json:"label"`
+	ReplacementTransport *ReplacementTransport `json:"replacementTransport"`
+	JourneyID            string                `json:"journeyID"`
+	Origin               Destination           `json:"origin"`
+	DifferingOrigin      *Destination          `json:"differingOrigin"`
+	Via                  []Via                 `json:"via"`
+}
+
+type Arrival struct {
+	Station           Station          `json:"station"`
+	JourneyID         string           `json:"journeyID"`
+	TimeSchedule      time.Time        `json:"timeSchedule"`
+	TimeType          string           `json:"timeType"`
+	Time              time.Time        `json:"time"`
+	OnDemand          bool             `json:"onDemand"`
+	PlatformSchedule  string           `json:"platformSchedule"`
+	Platform          string           `json:"platform"`
+	Administration    Administration   `json:"administration"`
+	Messages          []Message        `json:"messages"`
+	Disruptions       []Disruption     `json:"disruptions"`
+	Attributes        []Attribute      `json:"attributes"`
+	ArrivalID         string           `json:"arrivalID"`
+	Transport         ArrivalTransport `json:"transport"`
+	JourneyType       string           `json:"journeyType"`
+	Additional        bool             `json:"additional"`
+	Canceled          bool             `json:"canceled"`
+	ReliefFor         []TransportRef   `json:"reliefFor"`
+	ReliefBy          []TransportRef   `json:"reliefBy"`
+	ReplacementFor    []TransportRef   `json:"replacementFor"`
+	ReplacedBy        []TransportRef   `json:"replacedBy"`
+	TravelsWith       []TravelsWith    `json:"travelsWith"`
+	Codeshares        []Codeshare      `json:"codeshares"`
+	FutureDisruptions bool             `json:"futureDisruptions"`
+}
+
+type Journey struct {
+	JourneyID      string           `json:"journeyID"`
+	Administration Administration   `json:"administration"`
+	Transport      JourneyTransport `json:"transport"`
+	Origin         Station          `json:"origin"`
+	Destination    Station          `json:"destination"`
+	Canceled       bool             `json:"canceled"`
+	Stops          []JourneyStop    `json:"stops"`
+}
+
+type JourneyTransport struct {
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Number   int    `json:"number"`
+	Label    string `json:"label"`
+}
+
+type JourneyStop struct {
+	Station          Station       `json:"station"`
+	Arrival          *JourneyEvent `json:"arrival"`
+	Departure        *JourneyEvent `json:"departure"`
+	PlatformSchedule string        `json:"platformSchedule"`
+	Platform         string        `json:"platform"`
+	Canceled         bool          `json:"canceled"`
+	Additional       bool          `json:"additional"`
+	Arrived          bool          `json:"arrived"`
+	Departed         bool          `json:"departed"`
+}
+
+type JourneyEvent struct {
+	TimeSchedule time.Time `json:"timeSchedule"`
+	TimeType     string    `json:"timeType"`
+	Time         time.Time `json:"time"`
+	Canceled     bool      `json:"canceled"`
+}
@@ -0,0 +1,117 @@
+package delijn
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+func (p *Provider) LiveboardToRISArrivals(ctx context.Context, station string, opts ris.BoardOptions) ([]ris.Arrival, error) {
+	out := []ris.Arrival{}
+
+	resp, err := p.GetLiveboard(ctx, station, opts.MaxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	current := p.lookupStop(ctx, station)
+
+	lines := map[string]Line{}
+	for _, line := range resp.ServedLineDirections {
+		lines[line.ID] = line
+	}
+
+	for _, arrival := range resp.Trips {
+		if opts.MaxResults > 0 && len(out) >= opts.MaxResults {
+			break
+		}
+		if len(arrival.Passages) == 0 {
+			continue
+		}
+		passage := arrival.Passages[0]
+
+		// the first stop of a trip has no arrival time
+		planned := passage.PlannedPassage.ArrivalDateTime
+		if planned == "" {
+			continue
+		}
+		arrivalTime, _ := time.Parse("2006-01-02T15:04:05-0700", planned)
+
+		realTimeArrival := arrivalTime
+		if passage.RealtimePassage.ArrivalDateTime != "" {
+			realTimeArrival, _ = time.Parse("2006-01-02T15:04:05-0700", passage.RealtimePassage.ArrivalDateTime)
+		}
+
+		if realTimeArrival.Before(opts.Start()) || !opts.InWindow(realTimeArrival) {
+			continue
+		}
+
+		line := lines[arrival.LineDirection.ID]
+		transportType, category := transportInfo(line)
+		transportNumber := mustParseInt(line.Line.PublicLineNr)
+		canceled := arrival.TripStatus == "CANCELLED"
+
+		// the previous stops are all stops of the line before the current one
+		previous, _ := tripStops(p.lookupTripStops(ctx, station, line, arrival), station, arrival.PlaceDestination)
+
+		origin := ris.Destination{
+			Canceled: canceled,
+		}
+		if len(previous) > 0 {
+			origin.EvaNumber = previous[0].Haltenummer
+			origin.Name = previous[0].Name()
+		}
+
+		vias := []ris.Via{}
+		for _, stop := range previous {
+			vias = append(vias, ris.Via{
+				EvaNumber:       stop.Haltenummer,
+				Name:            stop.Name(),
+				Canceled:        canceled,
+				DisplayPriority: len(vias),
+			})
+		}
+
+		directionCode := mustParseInt(arrival.LineDirection.DirectionCode) + 1
+
+		out = append(out, ris.Arrival{
+			Station: ris.Station{
+				EvaNumber: station,
+				Name:      current.Name(),
+			},
+			JourneyID:        arrival.ID,
+			ArrivalID:        arrival.ID,
+			TimeSchedule:     arrivalTime,
+			Time:             realTimeArrival,
+			TimeType:         "PREVIEW",
+			Platform:         fmt.Sprintf("%d", directionCode),
+			PlatformSchedule: fmt.Sprintf("%d", directionCode),
+			Administration:   administration,
+			Disruptions:      []ris.Disruption{},
+			Attributes:       []ris.Attribute{},
+			Messages:         []ris.Message{},
+			JourneyType:      "REGULAR",
+			Canceled:         canceled,
+			ReliefFor:        []ris.TransportRef{},
+			ReliefBy:         []ris.TransportRef{},
+			ReplacementFor:   []ris.TransportRef{},
+			TravelsWith:      []ris.TravelsWith{},
+			Codeshares:       []ris.Codeshare{},
+			Transport: ris.ArrivalTransport{
+				Type:        transportType,
+				Category:    category,
+				Number:      transportNumber,
+				Line:        lineName(line),
+				LineDetails: lineDetails(line),
+				Label:       "",
+				JourneyID:   arrival.ID,
+				Origin:      origin,
+				Via:         vias,
+			},
+		})
+	}
+
+	return out, nil
+}
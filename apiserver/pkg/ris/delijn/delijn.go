@@ -1,35 +1,27 @@
 package delijn
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ratelimit"
 	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
 )
 
-const API_URL = "https://api.delijn.be"
-const USER_AGENT = "RIS-At-Home/1"
+// API_URL, KERN_API_URL and API_KEY configure the De Lijn API, they can be changed before the first request.
+// API_KEY has no default, get a subscription key from the De Lijn open data portal. Without
+// one De Lijn stops are reported as not supported.
+var API_URL = "https://api.delijn.be"
+var KERN_API_URL = "https://api.delijn.be/DLKernOpenData/api/v1"
+var API_KEY = ""
 
-var liveboardCache = make(map[string]Liveboard)
-var liveboardCacheMutex sync.RWMutex
-
-func init() {
-	// clear liveboard cache after 5 minutes
-	go func() {
-		for {
-			liveboardCacheMutex.Lock()
-			liveboardCache = make(map[string]Liveboard)
-			liveboardCacheMutex.Unlock()
-			time.Sleep(5 * time.Minute)
-		}
-	}()
-}
+const USER_AGENT = "RIS-At-Home/1"
 
 type Line struct {
 	ID            string `json:"id"`
@@ -50,89 +42,93 @@ type Line struct {
 }
 
 type Liveboard struct {
-	Trips []struct {
-		ID                  string `json:"id"`
-		Nr                  string `json:"nr"`
-		PlanningDestination string `json:"planningDestination"`
-		PlaceDestination    string `json:"placeDestination"`
-		PatternID           string `json:"patternId"`
-		PatternIDOriginal   string `json:"patternIdOriginal"`
-		Passages            []struct {
-			VisitNr        int `json:"visitNr"`
-			PlannedPassage struct {
-				ArrivalDateTime   string `json:"arrivalDateTime"`
-				DepartureDateTime string `json:"departureDateTime"`
-				ArrivalEpoch      int64  `json:"arrivalEpoch"`
-				DepartureEpoch    int64  `json:"departureEpoch"`
-			} `json:"plannedPassage"`
-			RealtimePassage struct {
-				ArrivalDateTime   string `json:"arrivalDateTime"`
-				DepartureDateTime string `json:"departureDateTime"`
-				ArrivalEpoch      int64  `json:"arrivalEpoch"`
-				DepartureEpoch    int64  `json:"departureEpoch"`
-			} `json:"realtimePassage"`
-			ScheduleType string `json:"scheduleType"`
-		} `json:"passages"`
-		LineDirection struct {
-			ID            string `json:"id"`
-			DirectionCode string `json:"directionCode"`
-			Line          struct {
-				ID string `json:"id"`
-			} `json:"line"`
-		} `json:"lineDirection"`
-		ExploitationDate string `json:"exploitationDate"`
-		DetourIds        []struct {
-			NetworkeventIdentifier string `json:"networkeventIdentifier"`
-			DetourIdentifier       string `json:"detourIdentifier"`
-		} `json:"detourIds,omitempty"`
-		TripStatus string `json:"tripStatus,omitempty"`
-	} `json:"trips"`
+	Trips                []Trip `json:"trips"`
 	ServedLineDirections []Line `json:"servedLineDirections"`
 }
 
-func GetLiveboard(stop string) (Liveboard, error) {
-	liveboardCacheMutex.RLock()
-	if liveboard, ok := liveboardCache[stop]; ok {
-		liveboardCacheMutex.RUnlock()
+type Trip struct {
+	ID                  string `json:"id"`
+	Nr                  string `json:"nr"`
+	PlanningDestination string `json:"planningDestination"`
+	PlaceDestination    string `json:"placeDestination"`
+	PatternID           string `json:"patternId"`
+	PatternIDOriginal   string `json:"patternIdOriginal"`
+	Passages            []struct {
+		VisitNr        int `json:"visitNr"`
+		PlannedPassage struct {
+			ArrivalDateTime   string `json:"arrivalDateTime"`
+			DepartureDateTime string `json:"departureDateTime"`
+			ArrivalEpoch      int64  `json:"arrivalEpoch"`
+			DepartureEpoch    int64  `json:"departureEpoch"`
+		} `json:"plannedPassage"`
+		RealtimePassage struct {
+			ArrivalDateTime   string `json:"arrivalDateTime"`
+			DepartureDateTime string `json:"departureDateTime"`
+			ArrivalEpoch      int64  `json:"arrivalEpoch"`
+			DepartureEpoch    int64  `json:"departureEpoch"`
+		} `json:"realtimePassage"`
+		ScheduleType string `json:"scheduleType"`
+	} `json:"passages"`
+	LineDirection struct {
+		ID            string `json:"id"`
+		DirectionCode string `json:"directionCode"`
+		Line          struct {
+			ID string `json:"id"`
+		} `json:"line"`
+	} `json:"lineDirection"`
+	ExploitationDate string `json:"exploitationDate"`
+	DetourIds        []struct {
+		NetworkeventIdentifier string `json:"networkeventIdentifier"`
+		DetourIdentifier       string `json:"detourIdentifier"`
+	} `json:"detourIds,omitempty"`
+	TripStatus string `json:"tripStatus,omitempty"`
+}
+
+// GetLiveboard returns the trips of a stop, fetched at most maxAge ago when maxAge is set
+func (p *Provider) GetLiveboard(ctx context.Context, stop string, maxAge time.Duration) (Liveboard, error) {
+	return p.Liveboards.FetchMaxAge(ctx, stop, maxAge, func(ctx context.Context) (Liveboard, error) {
+		var liveboard Liveboard
+		url := fmt.Sprintf("%s/travelinfo-trip/v1/stops/%s/trips", API_URL, stop)
+		if err := getJSON(ctx, url, &liveboard); err != nil {
+			return Liveboard{}, err
+		}
+
 		return liveboard, nil
-	}
-	liveboardCacheMutex.RUnlock()
+	})
+}
 
-	url := fmt.Sprintf("%s/travelinfo-trip/v1/stops/%s/trips", API_URL, stop)
-	req, err := http.NewRequest("GET", url, nil)
+// getJSON requests an URL from the De Lijn API and decodes the JSON response into out
+func getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return Liveboard{}, err
+		return err
 	}
 	req.Header.Set("User-Agent", USER_AGENT)
-	req.Header.Set("Ocp-Apim-Subscription-Key", "2ebe6ee98dc14965b22c294c436c9ac0")
+	req.Header.Set("Ocp-Apim-Subscription-Key", API_KEY)
 
-	client := &http.Client{}
+	client := ratelimit.NewClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return Liveboard{}, err
+		return err
 	}
 	defer resp.Body.Close()
-
-	var liveboard Liveboard
-	if err := json.NewDecoder(resp.Body).Decode(&liveboard); err != nil {
-		return Liveboard{}, err
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("delijn: unexpected status %s", resp.Status)
 	}
 
-	liveboardCacheMutex.Lock()
-	liveboardCache[stop] = liveboard
-	liveboardCacheMutex.Unlock()
-
-	return liveboard, nil
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func LiveboardToRISDepartures(station string) ([]ris.Departure, error) {
+func (p *Provider) LiveboardToRISDepartures(ctx context.Context, station string, opts ris.BoardOptions) ([]ris.Departure, error) {
 	out := []ris.Departure{}
 
-	resp, err := GetLiveboard(station)
+	resp, err := p.GetLiveboard(ctx, station, opts.MaxAge)
 	if err != nil {
 		return nil, err
 	}
 
+	current := p.lookupStop(ctx, station)
+
 	lines := map[string]Line{}
 
 	for _, line := range resp.ServedLineDirections {
@@ -140,33 +136,51 @@ func LiveboardToRISDepartures(station string) ([]ris.Departure, error) {
 	}
 
 	for _, departure := range resp.Trips {
-		departureTime, _ := time.Parse("2006-01-02T15:04:05-0700", departure.Passages[0].PlannedPassage.DepartureDateTime)
+		if opts.MaxResults > 0 && len(out) >= opts.MaxResults {
+			break
+		}
+		if len(departure.Passages) == 0 {
+			continue
+		}
+		passage := departure.Passages[0]
+		departureTime, _ := time.Parse("2006-01-02T15:04:05-0700", passage.PlannedPassage.DepartureDateTime)
 
 		realTimeDeparture := departureTime
-		if departure.Passages[0].RealtimePassage.DepartureDateTime != "" {
-			realTimeDeparture, _ = time.Parse("2006-01-02T15:04:05-0700", departure.Passages[0].RealtimePassage.DepartureDateTime)
+		if passage.RealtimePassage.DepartureDateTime != "" {
+			realTimeDeparture, _ = time.Parse("2006-01-02T15:04:05-0700", passage.RealtimePassage.DepartureDateTime)
 		}
 
-		if realTimeDeparture.Before(time.Now()) {
+		if realTimeDeparture.Before(opts.Start()) || !opts.InWindow(realTimeDeparture) {
 			continue
 		}
 
-		log.Println(departure)
-		transportType := "BUS"
-		log.Println(lines[departure.LineDirection.ID].Line.PublicLineNr)
-		transportNumber := mustParseInt(lines[departure.LineDirection.ID].Line.PublicLineNr)
+		line := lines[departure.LineDirection.ID]
+		transportType, category := transportInfo(line)
+		transportNumber := mustParseInt(line.Line.PublicLineNr)
+		canceled := departure.TripStatus == "CANCELLED"
+
+		_, ahead := tripStops(p.lookupTripStops(ctx, station, line, departure), station, departure.PlaceDestination)
+
+		destination := ris.Destination{
+			Name:     departure.PlaceDestination,
+			Canceled: canceled,
+		}
+		if len(ahead) > 0 {
+			destination.EvaNumber = ahead[len(ahead)-1].Haltenummer
+		}
 
 		stops := []ris.StopPlace{}
 		vias := []ris.Via{}
-		for _, stop := range strings.Split(lines[departure.LineDirection.ID].Line.Description, " - ") {
+		for _, stop := range ahead {
 			stops = append(stops, ris.StopPlace{
-				EvaNumber: stop,
-				Name:      stop,
+				EvaNumber: stop.Haltenummer,
+				Name:      stop.Name(),
 			})
 			vias = append(vias, ris.Via{
-				EvaNumber: stop,
-				Name:      stop,
-				Canceled:  departure.TripStatus == "CANCELLED",
+				EvaNumber:       stop.Haltenummer,
+				Name:            stop.Name(),
+				Canceled:        canceled,
+				DisplayPriority: len(vias),
 			})
 		}
 
@@ -174,8 +188,8 @@ func LiveboardToRISDepartures(station string) ([]ris.Departure, error) {
 
 		out = append(out, ris.Departure{
 			Station: ris.Station{
-				EvaNumber: departure.PlaceDestination,
-				Name:      "",
+				EvaNumber: station,
+				Name:      current.Name(),
 			},
 			JourneyID:        departure.ID,
 			DepartureID:      departure.ID,
@@ -184,36 +198,31 @@ func LiveboardToRISDepartures(station string) ([]ris.Departure, error) {
 			TimeType:         "PREVIEW",
 			Platform:         fmt.Sprintf("%d", directionCode),
 			PlatformSchedule: fmt.Sprintf("%d", directionCode),
-			Administration: ris.Administration{
-				AdministrationID: "0",
-				OperatorCode:     "---",
-				OperatorName:     "De Lijn",
-			},
-			Disruptions:    []any{},
-			Attributes:     []ris.Attribute{},
-			Messages:       []ris.Message{},
-			JourneyType:    "REGULAR",
-			ReliefFor:      []any{},
-			ReliefBy:       []any{},
-			ReplacementFor: []any{},
-			TravelsWith:    []any{},
-			Codeshares:     []any{},
+			Administration:   administration,
+			Disruptions:      []ris.Disruption{},
+			Attributes:       []ris.Attribute{},
+			Messages:         []ris.Message{},
+			JourneyType:      "REGULAR",
+			Canceled:         canceled,
+			ReliefFor:        []ris.TransportRef{},
+			ReliefBy:         []ris.TransportRef{},
+			ReplacementFor:   []ris.TransportRef{},
+			TravelsWith:      []ris.TravelsWith{},
+			Codeshares:       []ris.Codeshare{},
 			Transport: ris.Transport{
-				Type:      transportType,
-				Category:  "BUS",
-				Number:    transportNumber,
-				Label:     "",
-				JourneyID: departure.ID,
+				Type:        transportType,
+				Category:    category,
+				Number:      transportNumber,
+				Line:        lineName(line),
+				LineDetails: lineDetails(line),
+				Label:       "",
+				JourneyID:   departure.ID,
 				Direction: ris.Direction{
 					Text:       departure.PlaceDestination,
 					StopPlaces: stops,
 				},
-				Destination: ris.Destination{
-					EvaNumber: departure.PlaceDestination,
-					Name:      departure.PlaceDestination,
-					Canceled:  departure.TripStatus == "CANCELLED",
-				},
-				Via: vias,
+				Destination: destination,
+				Via:         vias,
 			},
 		})
 	}
@@ -221,6 +230,77 @@ func LiveboardToRISDepartures(station string) ([]ris.Departure, error) {
 	return out, nil
 }
 
+// lookupStop returns the stop metadata, falling back to a stop without a name when
+// it can not be fetched as a missing name should not take down the board
+func (p *Provider) lookupStop(ctx context.Context, stop string) Stop {
+	s, err := p.GetStop(ctx, stop)
+	if err != nil {
+		log.Printf("delijn: could not get stop %s: %v", stop, err)
+		return Stop{Haltenummer: stop}
+	}
+	return s
+}
+
+// lookupTripStops returns the stops served by the pattern of the trip, falling back to all stops
+// of the line direction when the pattern can not be fetched, or none when neither can be
+func (p *Provider) lookupTripStops(ctx context.Context, station string, line Line, trip Trip) []Stop {
+	lineStops, err := p.GetLineDirectionStops(ctx, entityOf(station), lineNumber(line), trip.LineDirection.DirectionCode)
+	if err != nil {
+		log.Printf("delijn: could not get stops of line %s: %v", line.ID, err)
+		return nil
+	}
+
+	stops, err := p.GetPatternStops(ctx, entityOf(station), lineNumber(line), trip, lineStops)
+	if err != nil {
+		log.Printf("delijn: could not get stops of pattern %s: %v", trip.PatternID, err)
+		return lineStops
+	}
+	return stops
+}
+
+// transportInfo maps the De Lijn transport type to a RIS type and category
+func transportInfo(line Line) (string, string) {
+	switch strings.ToUpper(line.Line.TransportType) {
+	case "TRAM":
+		return "TRAM", "TRAM"
+	case "METRO":
+		return "SUBWAY", "METRO"
+	default:
+		return "BUS", "BUS"
+	}
+}
+
+// lineName returns the public line number as shown on the vehicle, or nil when unknown
+func lineName(line Line) *string {
+	if line.Line.PublicLineNr == "" {
+		return nil
+	}
+	name := line.Line.PublicLineNr
+	return &name
+}
+
+func lineDetails(line Line) *ris.LineDetails {
+	if line.Line.PublicLineNr == "" {
+		return nil
+	}
+	return &ris.LineDetails{
+		Name:        line.Line.PublicLineNr,
+		Description: line.Line.Description,
+		Color: ris.LineColor{
+			Foreground:       line.Line.LineColor.Foreground,
+			ForegroundBorder: line.Line.LineColor.ForegroundBorder,
+			Background:       line.Line.LineColor.Background,
+			BackgroundBorder: line.Line.LineColor.BackgroundBorder,
+		},
+	}
+}
+
+var administration = ris.Administration{
+	AdministrationID: "0",
+	OperatorCode:     "---",
+	OperatorName:     "De Lijn",
+}
+
 func mustParseInt(s string) int {
 	i, err := strconv.Atoi(s)
 	if err != nil {
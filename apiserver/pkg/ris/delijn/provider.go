@@ -0,0 +1,55 @@
+package delijn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// Provider serves De Lijn stops
+type Provider struct {
+	// Liveboards holds the trips per stop
+	Liveboards *cache.Cache[string, Liveboard]
+	// Stops, LineStops and Patterns hold stop metadata, which only changes with a new timetable
+	Stops     *cache.Cache[string, Stop]
+	LineStops *cache.Cache[string, []Stop]
+	Patterns  *cache.Cache[string, []Stop]
+}
+
+func (p *Provider) Name() string {
+	return "delijn"
+}
+
+// Match matches numeric De Lijn stop numbers, leaving the 008 UIC codes to NMBS
+func (p *Provider) Match(id string) bool {
+	if id == "" || strings.HasPrefix(id, "008") {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// errNoAPIKey is returned for every stop when no API key is configured, so boards mixing
+// De Lijn stops with other stations still work
+var errNoAPIKey = fmt.Errorf("delijn: %w without an API key", ris.ErrNotSupported)
+
+func (p *Provider) Departures(ctx context.Context, id string, opts ris.BoardOptions) ([]ris.Departure, error) {
+	if API_KEY == "" {
+		return nil, errNoAPIKey
+	}
+	return p.LiveboardToRISDepartures(ctx, id, opts)
+}
+
+func (p *Provider) Arrivals(ctx context.Context, id string, opts ris.BoardOptions) ([]ris.Arrival, error) {
+	if API_KEY == "" {
+		return nil, errNoAPIKey
+	}
+	return p.LiveboardToRISArrivals(ctx, id, opts)
+}
@@ -0,0 +1,170 @@
+package delijn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Stop struct {
+	Entiteitnummer       string `json:"entiteitnummer"`
+	Haltenummer          string `json:"haltenummer"`
+	Omschrijving         string `json:"omschrijving"`
+	OmschrijvingLang     string `json:"omschrijvingLang"`
+	OmschrijvingGemeente string `json:"omschrijvingGemeente"`
+}
+
+func (s Stop) Name() string {
+	if s.OmschrijvingLang != "" {
+		return s.OmschrijvingLang
+	}
+	return s.Omschrijving
+}
+
+// matches reports if the stop is the one described by a trip destination text like "Gent Zuid",
+// which is the stop name with or without its municipality
+func (s Stop) matches(name string) bool {
+	name = normaliseName(name)
+	if name == "" {
+		return false
+	}
+	for _, n := range []string{s.Omschrijving, s.OmschrijvingLang, s.OmschrijvingGemeente + " " + s.Omschrijving} {
+		if normaliseName(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseName lowercases a stop name and drops its punctuation and extra spaces
+func normaliseName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// entityOf returns the De Lijn entity (province) of a stop number, which is its first digit
+func entityOf(stop string) string {
+	if stop == "" {
+		return ""
+	}
+	return stop[:1]
+}
+
+// direction maps a trip direction code to the HEEN/TERUG direction of the kern API
+func direction(code string) string {
+	switch strings.ToUpper(code) {
+	case "TERUG", "2":
+		return "TERUG"
+	default:
+		return "HEEN"
+	}
+}
+
+func (p *Provider) GetStop(ctx context.Context, stop string) (Stop, error) {
+	return p.Stops.Fetch(ctx, stop, func(ctx context.Context) (Stop, error) {
+		var s Stop
+		url := fmt.Sprintf("%s/haltes/%s/%s", KERN_API_URL, entityOf(stop), stop)
+		if err := getJSON(ctx, url, &s); err != nil {
+			return Stop{}, err
+		}
+		return s, nil
+	})
+}
+
+// GetLineDirectionStops returns all stops of a line in the given direction, in order
+func (p *Provider) GetLineDirectionStops(ctx context.Context, entity, line, directionCode string) ([]Stop, error) {
+	cacheName := fmt.Sprintf("%s-%s-%s", entity, line, direction(directionCode))
+	return p.LineStops.Fetch(ctx, cacheName, func(ctx context.Context) ([]Stop, error) {
+		var resp struct {
+			Haltes []Stop `json:"haltes"`
+		}
+		url := fmt.Sprintf("%s/lijnen/%s/%s/lijnrichtingen/%s/haltes", KERN_API_URL, entity, line, direction(directionCode))
+		if err := getJSON(ctx, url, &resp); err != nil {
+			return nil, err
+		}
+		return resp.Haltes, nil
+	})
+}
+
+// GetPatternStops returns the stops served by the pattern of a trip, in order. De Lijn has no
+// pattern endpoint, so the stops are taken from the trip's ride in the timetable of its line
+// direction and named after the line direction stops.
+func (p *Provider) GetPatternStops(ctx context.Context, entity, line string, trip Trip, lineStops []Stop) ([]Stop, error) {
+	if trip.PatternID == "" || trip.Nr == "" {
+		return nil, fmt.Errorf("trip %s has no pattern", trip.ID)
+	}
+
+	cacheName := fmt.Sprintf("%s-%s-%s", entity, line, trip.PatternID)
+	return p.Patterns.Fetch(ctx, cacheName, func(ctx context.Context) ([]Stop, error) {
+		var timetable struct {
+			RitDoorkomsten []struct {
+				Ritnummer   string `json:"ritnummer"`
+				Doorkomsten []struct {
+					Entiteitnummer string `json:"entiteitnummer"`
+					Haltenummer    string `json:"haltenummer"`
+				} `json:"doorkomsten"`
+			} `json:"ritDoorkomsten"`
+		}
+		url := fmt.Sprintf("%s/lijnen/%s/%s/lijnrichtingen/%s/dienstregelingen", KERN_API_URL, entity, line, direction(trip.LineDirection.DirectionCode))
+		if len(trip.ExploitationDate) >= 10 {
+			url += "?datum=" + trip.ExploitationDate[:10]
+		}
+		if err := getJSON(ctx, url, &timetable); err != nil {
+			return nil, err
+		}
+
+		names := map[string]Stop{}
+		for _, stop := range lineStops {
+			names[stop.Haltenummer] = stop
+		}
+
+		for _, ride := range timetable.RitDoorkomsten {
+			if ride.Ritnummer != trip.Nr {
+				continue
+			}
+			stops := make([]Stop, 0, len(ride.Doorkomsten))
+			for _, passage := range ride.Doorkomsten {
+				stop, ok := names[passage.Haltenummer]
+				if !ok {
+					stop = p.lookupStop(ctx, passage.Haltenummer)
+				}
+				stops = append(stops, stop)
+			}
+			return stops, nil
+		}
+		return nil, fmt.Errorf("trip %s not found in the timetable", trip.Nr)
+	})
+}
+
+// tripStops splits the stops of a line direction in the stops before and after the
+// current stop, the stops after end at the trip destination when it can be found
+func tripStops(lineStops []Stop, current, destination string) ([]Stop, []Stop) {
+	for i, stop := range lineStops {
+		if stop.Haltenummer != current {
+			continue
+		}
+
+		before := lineStops[:i]
+		after := lineStops[i+1:]
+		for j, stop := range after {
+			if stop.matches(destination) {
+				after = after[:j+1]
+				break
+			}
+		}
+		return before, after
+	}
+
+	return nil, nil
+}
+
+// lineNumber returns the internal line number used by the kern API
+func lineNumber(line Line) string {
+	id := line.Line.ID
+	if i := strings.LastIndex(id, "-"); i >= 0 {
+		id = id[i+1:]
+	}
+	return id
+}
@@ -0,0 +1,140 @@
+package ris
+
+import (
+	"fmt"
+	"time"
+)
+
+// Change types reported by DiffDepartures
+const (
+	ChangeAdded           = "ADDED"
+	ChangeAdditional      = "ADDITIONAL"
+	ChangeRemoved         = "REMOVED"
+	ChangeDelayIncreased  = "DELAY_INCREASED"
+	ChangeDelayDecreased  = "DELAY_DECREASED"
+	ChangePlatformChanged = "PLATFORM_CHANGED"
+	ChangeCanceled        = "CANCELED"
+)
+
+// DepartureChange is one change to a departure between two snapshots of a board. Departure is
+// the current state, or the last known state for removed departures.
+type DepartureChange struct {
+	Type        string    `json:"type"`
+	DepartureID string    `json:"departureID"`
+	Departure   Departure `json:"departure"`
+
+	// Delay and PreviousDelay are in seconds, set for delay changes
+	Delay         int `json:"delay,omitempty"`
+	PreviousDelay int `json:"previousDelay,omitempty"`
+
+	// Platform and PreviousPlatform are set for platform changes
+	Platform         string `json:"platform,omitempty"`
+	PreviousPlatform string `json:"previousPlatform,omitempty"`
+}
+
+// DiffDepartures compares two snapshots of a board by DepartureID. It reports departures that
+// appeared (ADDITIONAL for extra trains, ADDED otherwise), left the board, got more or less
+// delay, moved away from (or back to) their scheduled platform or got canceled. Changes follow
+// the order of current, removed departures come last.
+func DiffDepartures(previous, current []Departure) []DepartureChange {
+	var changes []DepartureChange
+
+	before := make(map[string]Departure, len(previous))
+	for _, d := range previous {
+		before[d.DepartureID] = d
+	}
+
+	seen := make(map[string]bool, len(current))
+	for _, d := range current {
+		seen[d.DepartureID] = true
+
+		old, ok := before[d.DepartureID]
+		if !ok {
+			change := DepartureChange{Type: ChangeAdded, DepartureID: d.DepartureID, Departure: d}
+			if d.Additional {
+				change.Type = ChangeAdditional
+			}
+			changes = append(changes, change)
+			continue
+		}
+
+		if delay, oldDelay := Delay(d), Delay(old); delay != oldDelay {
+			change := DepartureChange{
+				Type:          ChangeDelayIncreased,
+				DepartureID:   d.DepartureID,
+				Departure:     d,
+				Delay:         int(delay.Seconds()),
+				PreviousDelay: int(oldDelay.Seconds()),
+			}
+			if delay < oldDelay {
+				change.Type = ChangeDelayDecreased
+			}
+			changes = append(changes, change)
+		}
+		// a platform being assigned as scheduled is no change, moving away from or back to it is
+		if d.Platform != old.Platform && d.Platform != "" && (PlatformChanged(d) || PlatformChanged(old)) {
+			changes = append(changes, DepartureChange{
+				Type:             ChangePlatformChanged,
+				DepartureID:      d.DepartureID,
+				Departure:        d,
+				Platform:         d.Platform,
+				PreviousPlatform: old.Platform,
+			})
+		}
+		if d.Canceled && !old.Canceled {
+			changes = append(changes, DepartureChange{Type: ChangeCanceled, DepartureID: d.DepartureID, Departure: d})
+		}
+	}
+
+	for _, d := range previous {
+		if !seen[d.DepartureID] {
+			changes = append(changes, DepartureChange{Type: ChangeRemoved, DepartureID: d.DepartureID, Departure: d})
+		}
+	}
+
+	return changes
+}
+
+// Delay returns how late a departure is compared to its schedule
+func Delay(d Departure) time.Duration {
+	if d.Time.IsZero() || d.TimeSchedule.IsZero() {
+		return 0
+	}
+	return d.Time.Sub(d.TimeSchedule)
+}
+
+// PlatformChanged reports if a departure leaves from another platform than scheduled
+func PlatformChanged(d Departure) bool {
+	return d.Platform != "" && d.PlatformSchedule != "" && d.Platform != d.PlatformSchedule
+}
+
+// String describes the change for logs, eg. "IC 1234 to Gent-Sint-Pieters at 17:42: delay increased from 0s to 5m0s"
+func (c DepartureChange) String() string {
+	d := c.Departure
+	name := fmt.Sprintf("%s %d", d.Transport.Category, d.Transport.Number)
+	if d.Transport.Line != nil {
+		name = fmt.Sprintf("%s %s", d.Transport.Category, *d.Transport.Line)
+	}
+	subject := fmt.Sprintf("%s to %s at %s", name, d.Transport.Destination.Name, d.TimeSchedule.Format("15:04"))
+
+	switch c.Type {
+	case ChangeDelayIncreased:
+		return fmt.Sprintf("%s: delay increased from %s to %s", subject, time.Duration(c.PreviousDelay)*time.Second, time.Duration(c.Delay)*time.Second)
+	case ChangeDelayDecreased:
+		return fmt.Sprintf("%s: delay decreased from %s to %s", subject, time.Duration(c.PreviousDelay)*time.Second, time.Duration(c.Delay)*time.Second)
+	case ChangePlatformChanged:
+		if c.PreviousPlatform == "" {
+			return fmt.Sprintf("%s: platform changed to %s", subject, c.Platform)
+		}
+		return fmt.Sprintf("%s: platform changed from %s to %s", subject, c.PreviousPlatform, c.Platform)
+	case ChangeCanceled:
+		return subject + ": canceled"
+	case ChangeAdditional:
+		return subject + ": additional train"
+	case ChangeAdded:
+		return subject + ": added"
+	case ChangeRemoved:
+		return subject + ": removed"
+	}
+	return subject + ": " + c.Type
+}
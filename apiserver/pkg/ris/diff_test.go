@@ -0,0 +1,130 @@
+package ris
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffDepartures(t *testing.T) {
+	at := time.Date(2024, 1, 31, 17, 42, 0, 0, time.UTC)
+	departure := func(id string, modify func(*Departure)) Departure {
+		d := Departure{DepartureID: id, TimeSchedule: at, Time: at, Platform: "3", PlatformSchedule: "3"}
+		if modify != nil {
+			modify(&d)
+		}
+		return d
+	}
+
+	tests := []struct {
+		name     string
+		previous []Departure
+		current  []Departure
+		want     []DepartureChange
+	}{
+		{
+			name:     "no changes",
+			previous: []Departure{departure("a", nil)},
+			current:  []Departure{departure("a", nil)},
+		},
+		{
+			name:    "added",
+			current: []Departure{departure("a", nil)},
+			want:    []DepartureChange{{Type: ChangeAdded, DepartureID: "a"}},
+		},
+		{
+			name:    "additional",
+			current: []Departure{departure("a", func(d *Departure) { d.Additional = true })},
+			want:    []DepartureChange{{Type: ChangeAdditional, DepartureID: "a"}},
+		},
+		{
+			name:     "removed",
+			previous: []Departure{departure("a", nil)},
+			want:     []DepartureChange{{Type: ChangeRemoved, DepartureID: "a"}},
+		},
+		{
+			name:     "delay increased",
+			previous: []Departure{departure("a", nil)},
+			current:  []Departure{departure("a", func(d *Departure) { d.Time = at.Add(5 * time.Minute) })},
+			want:     []DepartureChange{{Type: ChangeDelayIncreased, DepartureID: "a", Delay: 300}},
+		},
+		{
+			name:     "delay decreased",
+			previous: []Departure{departure("a", func(d *Departure) { d.Time = at.Add(5 * time.Minute) })},
+			current:  []Departure{departure("a", func(d *Departure) { d.Time = at.Add(2 * time.Minute) })},
+			want:     []DepartureChange{{Type: ChangeDelayDecreased, DepartureID: "a", Delay: 120, PreviousDelay: 300}},
+		},
+		{
+			name:     "canceled",
+			previous: []Departure{departure("a", nil)},
+			current:  []Departure{departure("a", func(d *Departure) { d.Canceled = true })},
+			want:     []DepartureChange{{Type: ChangeCanceled, DepartureID: "a"}},
+		},
+		{
+			name:     "scheduled platform assigned",
+			previous: []Departure{departure("a", func(d *Departure) { d.Platform, d.PlatformSchedule = "", "" })},
+			current:  []Departure{departure("a", nil)},
+		},
+		{
+			name:     "moved from the scheduled platform",
+			previous: []Departure{departure("a", nil)},
+			current:  []Departure{departure("a", func(d *Departure) { d.Platform = "5" })},
+			want:     []DepartureChange{{Type: ChangePlatformChanged, DepartureID: "a", Platform: "5", PreviousPlatform: "3"}},
+		},
+		{
+			name:     "moved back to the scheduled platform",
+			previous: []Departure{departure("a", func(d *Departure) { d.Platform = "5" })},
+			current:  []Departure{departure("a", nil)},
+			want:     []DepartureChange{{Type: ChangePlatformChanged, DepartureID: "a", Platform: "3", PreviousPlatform: "5"}},
+		},
+		{
+			// iRail only tells a train moved, with a scheduled platform of 0
+			name:     "irail moved from the scheduled platform",
+			previous: []Departure{departure("a", nil)},
+			current:  []Departure{departure("a", func(d *Departure) { d.Platform, d.PlatformSchedule = "5", "0" })},
+			want:     []DepartureChange{{Type: ChangePlatformChanged, DepartureID: "a", Platform: "5", PreviousPlatform: "3"}},
+		},
+		{
+			name:     "irail moved back to the scheduled platform",
+			previous: []Departure{departure("a", func(d *Departure) { d.Platform, d.PlatformSchedule = "5", "0" })},
+			current:  []Departure{departure("a", nil)},
+			want:     []DepartureChange{{Type: ChangePlatformChanged, DepartureID: "a", Platform: "3", PreviousPlatform: "5"}},
+		},
+		{
+			name:     "irail moved again",
+			previous: []Departure{departure("a", func(d *Departure) { d.Platform, d.PlatformSchedule = "5", "0" })},
+			current:  []Departure{departure("a", func(d *Departure) { d.Platform, d.PlatformSchedule = "6", "0" })},
+			want:     []DepartureChange{{Type: ChangePlatformChanged, DepartureID: "a", Platform: "6", PreviousPlatform: "5"}},
+		},
+		{
+			name:     "changes follow current, removed last",
+			previous: []Departure{departure("a", nil), departure("b", nil)},
+			current:  []Departure{departure("c", nil), departure("b", func(d *Departure) { d.Canceled = true })},
+			want: []DepartureChange{
+				{Type: ChangeAdded, DepartureID: "c"},
+				{Type: ChangeCanceled, DepartureID: "b"},
+				{Type: ChangeRemoved, DepartureID: "a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffDepartures(tt.previous, tt.current)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d changes %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				c := got[i]
+				if c.Type != want.Type || c.DepartureID != want.DepartureID || c.Delay != want.Delay ||
+					c.PreviousDelay != want.PreviousDelay || c.Platform != want.Platform || c.PreviousPlatform != want.PreviousPlatform {
+					t.Errorf("change %d = %s %s delay %d from %d platform %q from %q, want %s %s delay %d from %d platform %q from %q",
+						i, c.Type, c.DepartureID, c.Delay, c.PreviousDelay, c.Platform, c.PreviousPlatform,
+						want.Type, want.DepartureID, want.Delay, want.PreviousDelay, want.Platform, want.PreviousPlatform)
+				}
+				if c.DepartureID != "" && c.Departure.DepartureID != c.DepartureID {
+					t.Errorf("change %d carries departure %q, want %q", i, c.Departure.DepartureID, c.DepartureID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package ris
+
+// CollectDisruptions returns the unique disruptions of all given board entries,
+// used to fill the board wide disruption list
+func CollectDisruptions[T any](entries []T, disruptionsOf func(T) []Disruption) []Disruption {
+	out := []Disruption{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		for _, disruption := range disruptionsOf(entry) {
+			if seen[disruption.DisruptionID] {
+				continue
+			}
+			seen[disruption.DisruptionID] = true
+			out = append(out, disruption)
+		}
+	}
+	return out
+}
@@ -0,0 +1,222 @@
+package ris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// FetchDepartures looks up the departures for all given station IDs using at most
+// concurrency parallel fetches and returns them merged and sorted on TimeSchedule.
+// Stations that fail or do not finish before ctx is done are left out and reported as warnings.
+// The merged board is cut to the count, time window and via stops of opts.
+func FetchDepartures(ctx context.Context, stations []string, opts BoardOptions, concurrency int) ([]Departure, []Warning) {
+	departures, warnings := fetchAll(ctx, stations, concurrency, func(ctx context.Context, p Provider, id string) ([]Departure, error) {
+		return fetchMatching(opts, opts.Filter.MatchDeparture, func(opts BoardOptions) ([]Departure, error) {
+			return p.Departures(ctx, id, opts)
+		})
+	}, func(d Departure) time.Time {
+		return d.TimeSchedule
+	})
+
+	departures = limitBoard(departures, opts, func(d Departure) time.Time { return d.Time }, opts.Filter.MatchDeparture)
+	if opts.MaxViaStops != nil {
+		for i := range departures {
+			departures[i].Transport.Via = limitVias(departures[i].Transport.Via, *opts.MaxViaStops)
+		}
+	}
+
+	return departures, warnings
+}
+
+// FetchArrivals is FetchDepartures for arrivals, stations whose provider has no
+// arrivals support are reported as warnings.
+func FetchArrivals(ctx context.Context, stations []string, opts BoardOptions, concurrency int) ([]Arrival, []Warning) {
+	arrivals, warnings := fetchAll(ctx, stations, concurrency, func(ctx context.Context, p Provider, id string) ([]Arrival, error) {
+		ap, ok := p.(ArrivalsProvider)
+		if !ok {
+			return nil, fmt.Errorf("arrivals %w", ErrNotSupported)
+		}
+		return fetchMatching(opts, opts.Filter.MatchArrival, func(opts BoardOptions) ([]Arrival, error) {
+			return ap.Arrivals(ctx, id, opts)
+		})
+	}, func(a Arrival) time.Time {
+		return a.TimeSchedule
+	})
+
+	arrivals = limitBoard(arrivals, opts, func(a Arrival) time.Time { return a.Time }, opts.Filter.MatchArrival)
+	if opts.MaxViaStops != nil {
+		for i := range arrivals {
+			// the stops closest to the station are the most relevant previous stops
+			via := arrivals[i].Transport.Via
+			if len(via) > *opts.MaxViaStops {
+				arrivals[i].Transport.Via = via[len(via)-*opts.MaxViaStops:]
+			}
+		}
+	}
+
+	return arrivals, warnings
+}
+
+// fetchMatching fetches the board of a station, asking the provider for a longer board until
+// enough entries match the filter of opts to fill the board. It stops when the provider returns
+// fewer entries than asked for, as its board or time window ended, or at LimitMaxResults.
+func fetchMatching[T any](opts BoardOptions, match func(T) bool, fetch func(BoardOptions) ([]T, error)) ([]T, error) {
+	providerOpts := opts.providerOptions()
+	for {
+		entries, err := fetch(providerOpts)
+		if err != nil || opts.Filter.IsEmpty() {
+			return entries, err
+		}
+
+		matched := 0
+		for _, entry := range entries {
+			if match(entry) {
+				matched++
+			}
+		}
+		if matched >= opts.Limit() || len(entries) < providerOpts.Limit() || providerOpts.Limit() >= LimitMaxResults {
+			return entries, nil
+		}
+		providerOpts.MaxResults = min(providerOpts.Limit()*2, LimitMaxResults)
+	}
+}
+
+// limitBoard drops the entries outside the time window of opts or not matching the filter
+// and cuts the board to MaxResults
+func limitBoard[T any](entries []T, opts BoardOptions, timeOf func(T) time.Time, match func(T) bool) []T {
+	out := entries[:0]
+	for _, entry := range entries {
+		if opts.InWindow(timeOf(entry)) && match(entry) {
+			out = append(out, entry)
+		}
+	}
+
+	if opts.MaxResults > 0 && len(out) > opts.MaxResults {
+		out = out[:opts.MaxResults]
+	}
+	return out
+}
+
+func limitVias(via []Via, max int) []Via {
+	if len(via) <= max {
+		return via
+	}
+	return via[:max]
+}
+
+func fetchAll[T any](ctx context.Context, stations []string, concurrency int, fetch func(context.Context, Provider, string) ([]T, error), timeOf func(T) time.Time) ([]T, []Warning) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type result struct {
+		index    int
+		provider string
+		items    []T
+		err      error
+	}
+	// buffered so workers never block on a caller that gave up
+	results := make(chan result, len(stations))
+
+	jobs := make(chan int)
+	for w := 0; w < concurrency && w < len(stations); w++ {
+		go func() {
+			for i := range jobs {
+				provider, id, err := Lookup(stations[i])
+				if err != nil {
+					results <- result{index: i, err: err}
+					continue
+				}
+				items, err := fetchStation(ctx, provider, id, fetch)
+				results <- result{index: i, provider: provider.Name(), items: items, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range stations {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	out := []T{}
+	warnings := []Warning{}
+	finished := make([]bool, len(stations))
+
+collect:
+	for range stations {
+		select {
+		case r := <-results:
+			finished[r.index] = true
+			if r.err != nil {
+				warnings = append(warnings, newFetchWarning(stations[r.index], r.provider, r.err))
+				continue
+			}
+			out = append(out, r.items...)
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	for i, ok := range finished {
+		if ok {
+			continue
+		}
+		warning := Warning{
+			Station: stations[i],
+			Code:    WarningTimeout,
+			Text:    "station did not respond in time",
+		}
+		if provider, _, err := Lookup(stations[i]); err == nil {
+			warning.Provider = provider.Name()
+		}
+		warnings = append(warnings, warning)
+	}
+
+	// sort on TimeSchedule
+	sort.SliceStable(out, func(i, j int) bool {
+		return timeOf(out[i]).Before(timeOf(out[j]))
+	})
+
+	return out, warnings
+}
+
+// fetchStation calls fetch for one station, a panicking provider is turned into an error
+// as it runs outside of the request goroutine and would take the whole server down
+func fetchStation[T any](ctx context.Context, provider Provider, id string, fetch func(context.Context, Provider, string) ([]T, error)) (items []T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("provider %s panicked on %s: %v\n%s", provider.Name(), id, r, debug.Stack())
+			items, err = nil, fmt.Errorf("%s: internal error", provider.Name())
+		}
+	}()
+	return fetch(ctx, provider, id)
+}
+
+func newFetchWarning(station, provider string, err error) Warning {
+	code := WarningUpstreamError
+	if errors.Is(err, ErrNoProvider) {
+		code = WarningUnknownStation
+	} else if errors.Is(err, ErrNotSupported) {
+		code = WarningNotSupported
+	} else if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		code = WarningTimeout
+	}
+
+	return Warning{
+		Station:  station,
+		Provider: provider,
+		Code:     code,
+		Text:     err.Error(),
+	}
+}
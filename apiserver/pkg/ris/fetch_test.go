@@ -0,0 +1,41 @@
+package ris
+
+import "testing"
+
+func TestFetchMatchingPagesUntilBoardIsFull(t *testing.T) {
+	// every fifth entry is on platform 3
+	fetch := func(available int) func(BoardOptions) ([]Departure, error) {
+		return func(opts BoardOptions) ([]Departure, error) {
+			var out []Departure
+			for i := 0; i < min(opts.Limit(), available); i++ {
+				platform := "1"
+				if i%5 == 0 {
+					platform = "3"
+				}
+				out = append(out, Departure{Platform: platform})
+			}
+			return out, nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		maxResults int
+		available  int
+		want       int
+	}{
+		{"board fills up", 8, 1000, 60},
+		{"station runs out", 8, 45, 45},
+		{"limit reached", LimitMaxResults, 10000, LimitMaxResults},
+	}
+	for _, tt := range tests {
+		opts := BoardOptions{MaxResults: tt.maxResults, Filter: Filter{Platforms: []string{"3"}}}
+		entries, err := fetchMatching(opts, opts.Filter.MatchDeparture, fetch(tt.available))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(entries) != tt.want {
+			t.Errorf("%s: fetched %d entries, want %d", tt.name, len(entries), tt.want)
+		}
+	}
+}
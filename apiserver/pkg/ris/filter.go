@@ -0,0 +1,116 @@
+package ris
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Filter selects board entries on their transport, line, platform, destination and operator.
+// Every non-empty list has to match, values within a list are alternatives.
+type Filter struct {
+	TransportTypes []string
+	Lines          []string
+	Platforms      []string
+	Destinations   []string
+	Operators      []string
+}
+
+// ParseFilter reads the transportTypes, lines, platforms, destinations and operators
+// parameters from the query string, each as a comma separated or repeated parameter
+func ParseFilter(query url.Values) Filter {
+	return Filter{
+		TransportTypes: queryList(query, "transportTypes"),
+		Lines:          queryList(query, "lines"),
+		Platforms:      queryList(query, "platforms"),
+		Destinations:   queryList(query, "destinations"),
+		Operators:      queryList(query, "operators"),
+	}
+}
+
+func queryList(query url.Values, name string) []string {
+	var out []string
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
+}
+
+// IsEmpty reports if the filter lets every entry through
+func (f Filter) IsEmpty() bool {
+	return len(f.TransportTypes) == 0 && len(f.Lines) == 0 && len(f.Platforms) == 0 &&
+		len(f.Destinations) == 0 && len(f.Operators) == 0
+}
+
+// MatchDeparture reports if a departure passes the filter
+func (f Filter) MatchDeparture(d Departure) bool {
+	return f.match(d.Transport.Type, lineNames(d.Transport.Line, d.Transport.Category, d.Transport.Number),
+		d.Platform, d.Transport.Destination, d.Administration)
+}
+
+// MatchArrival reports if an arrival passes the filter, for arrivals destinations match the origin
+func (f Filter) MatchArrival(a Arrival) bool {
+	return f.match(a.Transport.Type, lineNames(a.Transport.Line, a.Transport.Category, a.Transport.Number),
+		a.Platform, a.Transport.Origin, a.Administration)
+}
+
+func (f Filter) match(transportType string, lines []string, platform string, destination Destination, administration Administration) bool {
+	if len(f.TransportTypes) > 0 && !containsFold(f.TransportTypes, transportType) {
+		return false
+	}
+	if len(f.Lines) > 0 && !anyContainsFold(f.Lines, lines) {
+		return false
+	}
+	if len(f.Platforms) > 0 && !containsFold(f.Platforms, platform) {
+		return false
+	}
+	if len(f.Destinations) > 0 && !matchDestination(f.Destinations, destination) {
+		return false
+	}
+	if len(f.Operators) > 0 && !anyContainsFold(f.Operators, []string{administration.OperatorName, administration.OperatorCode, administration.AdministrationID}) {
+		return false
+	}
+	return true
+}
+
+// lineNames returns the names a line can be filtered on, eg. "S1", "IC" or "IC 1234"
+func lineNames(line *string, category string, number int) []string {
+	names := []string{category, fmt.Sprintf("%s %d", category, number), fmt.Sprintf("%s%d", category, number)}
+	if line != nil {
+		names = append(names, *line)
+	}
+	return names
+}
+
+// matchDestination matches a destination on its exact station ID or a part of its name
+func matchDestination(wanted []string, destination Destination) bool {
+	name := strings.ToLower(destination.Name)
+	for _, w := range wanted {
+		if w == destination.EvaNumber || (name != "" && strings.Contains(name, strings.ToLower(w))) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyContainsFold(list []string, values []string) bool {
+	for _, value := range values {
+		if value != "" && containsFold(list, value) {
+			return true
+		}
+	}
+	return false
+}
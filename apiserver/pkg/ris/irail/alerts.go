@@ -0,0 +1,114 @@
+package irail
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+type Alert struct {
+	ID          string `json:"id"`
+	Header      string `json:"header"`
+	Description string `json:"description"`
+	Lead        string `json:"lead"`
+	Link        string `json:"link"`
+	StartTime   string `json:"startTime"`
+	EndTime     string `json:"endTime"`
+}
+
+type Alerts struct {
+	Number string  `json:"number"`
+	Alert  []Alert `json:"alert"`
+}
+
+// UnmarshalJSON ignores alerts that are not an object, iRail omits or empties the
+// field in several ways and a malformed alert should never break a whole board
+func (a *Alerts) UnmarshalJSON(data []byte) error {
+	type alerts Alerts
+	var out alerts
+	if err := json.Unmarshal(data, &out); err != nil {
+		*a = Alerts{}
+		return nil
+	}
+	*a = Alerts(out)
+	return nil
+}
+
+// strike and construction keywords in the languages iRail serves, matched as whole words
+var (
+	strikeKeywords       = []string{"strike", "strikes", "staking", "stakingen", "grève", "grèves", "greve", "streik", "streiks"}
+	constructionKeywords = []string{"works", "werken", "werkzaamheden", "travaux", "bauarbeiten"}
+)
+
+func alertType(alert Alert) string {
+	words := strings.FieldsFunc(strings.ToLower(alert.Header+" "+alert.Description), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	hasKeyword := func(keywords []string) bool {
+		return slices.ContainsFunc(words, func(word string) bool { return slices.Contains(keywords, word) })
+	}
+
+	if hasKeyword(strikeKeywords) {
+		return ris.DisruptionTypeStrike
+	}
+	if hasKeyword(constructionKeywords) {
+		return ris.DisruptionTypeConstruction
+	}
+	return ris.DisruptionTypeIncident
+}
+
+// alertsToRIS maps iRail alerts to RIS messages and disruptions, skipping duplicates
+func alertsToRIS(alerts Alerts) ([]ris.Message, []ris.Disruption) {
+	messages := []ris.Message{}
+	disruptions := []ris.Disruption{}
+	seen := map[string]bool{}
+
+	for _, alert := range alerts.Alert {
+		// iRail alert IDs are positions in the list, so identify them by content
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%s|%s|%s", alert.Header, alert.StartTime, alert.EndTime)
+		id := fmt.Sprintf("irail:%x", h.Sum64())
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		text := alert.Description
+		if text == "" {
+			text = alert.Lead
+		}
+
+		disruption := ris.Disruption{
+			DisruptionID:    id,
+			Type:            alertType(alert),
+			DisplayPriority: len(disruptions),
+			Text:            text,
+			TextShort:       alert.Header,
+			Link:            alert.Link,
+		}
+		if alert.StartTime != "" {
+			start := unixTimeToTime(alert.StartTime)
+			disruption.TimeStart = &start
+		}
+		if alert.EndTime != "" {
+			end := unixTimeToTime(alert.EndTime)
+			disruption.TimeEnd = &end
+		}
+		disruptions = append(disruptions, disruption)
+
+		messages = append(messages, ris.Message{
+			Code:            id,
+			Type:            disruption.Type,
+			DisplayPriority: disruption.DisplayPriority,
+			Text:            text,
+			TextShort:       alert.Header,
+		})
+	}
+
+	return messages, disruptions
+}
@@ -0,0 +1,84 @@
+package irail
+
+import (
+	"context"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+func (p *Provider) LiveboardToRISArrivals(ctx context.Context, station string, opts ris.BoardOptions) ([]ris.Arrival, error) {
+	out := []ris.Arrival{}
+	board, err := p.GetBoard(ctx, station, "arrivals", opts)
+	if err != nil {
+		return nil, err
+	}
+	liveboard, sncbArrivals := board.Liveboard, board.Entries
+
+	vehicles := p.getVehicles(ctx, sncbArrivals)
+	for i, arrival := range sncbArrivals {
+		arrivalTime := unixTimeToTime(arrival.Time)
+		vehicle := vehicles[i]
+
+		delay, timeType := delayAndTimeType(arrival)
+		journeyID := JourneyID(arrival.Vehicle, arrivalTime)
+		messages, disruptions := alertsToRIS(arrival.Alerts)
+		transportType, transportName, transportNumber := transportInfo(arrival.Vehicleinfo.Shortname)
+
+		// the previous stops are all stops before the current one
+		vias := []ris.Via{}
+		for _, stop := range vehicle.Stops.Stop {
+			if stop.Station == liveboard.Station {
+				break
+			}
+
+			vias = append(vias, ris.Via{
+				Name:            stop.Station,
+				EvaNumber:       stop.Stationinfo.ID,
+				Canceled:        stop.Canceled == "1",
+				DisplayPriority: len(vias),
+			})
+		}
+
+		out = append(out, ris.Arrival{
+			Station: ris.Station{
+				EvaNumber: liveboard.Stationinfo.ID,
+				Name:      liveboard.Stationinfo.Name,
+			},
+			JourneyID:        journeyID,
+			ArrivalID:        journeyID + "@" + liveboard.Stationinfo.ID,
+			TimeSchedule:     arrivalTime,
+			Time:             arrivalTime.Add(time.Duration(delay) * time.Second),
+			TimeType:         timeType,
+			Platform:         arrival.Platforminfo.Name,
+			PlatformSchedule: scheduledPlatform(arrival),
+			Administration:   administration,
+			Disruptions:      disruptions,
+			Attributes:       []ris.Attribute{},
+			Messages:         messages,
+			JourneyType:      "REGULAR",
+			Additional:       arrival.IsExtra == "1",
+			Canceled:         arrival.Canceled == "1",
+			ReliefFor:        []ris.TransportRef{},
+			ReliefBy:         []ris.TransportRef{},
+			ReplacementFor:   []ris.TransportRef{},
+			TravelsWith:      []ris.TravelsWith{},
+			Codeshares:       []ris.Codeshare{},
+			Transport: ris.ArrivalTransport{
+				Type:      transportType,
+				Category:  transportName,
+				Number:    transportNumber,
+				Label:     "",
+				JourneyID: journeyID,
+				Origin: ris.Destination{
+					EvaNumber: arrival.Stationinfo.ID,
+					Name:      arrival.Stationinfo.Name,
+					Canceled:  arrival.Canceled == "1",
+				},
+				Via: vias,
+			},
+		})
+	}
+
+	return out, nil
+}
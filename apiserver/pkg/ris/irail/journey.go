@@ -0,0 +1,119 @@
+package irail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// JourneyID builds the RIS journey ID for a vehicle (eg. BE.NMBS.IC1234) running on the
+// service day of the given time, in the form "irail:BE.NMBS.IC1234_20240131"
+func JourneyID(vehicle string, t time.Time) string {
+	tz, _ := time.LoadLocation("Europe/Brussels")
+	return fmt.Sprintf("irail:%s_%s", vehicle, t.In(tz).Format("20060102"))
+}
+
+// parseJourneyID is the reverse of JourneyID, without the "irail:" prefix
+func parseJourneyID(id string) (string, time.Time, error) {
+	id = strings.TrimPrefix(id, "irail:")
+	vehicle, date, ok := strings.Cut(id, "_")
+	if !ok || vehicle == "" {
+		return "", time.Time{}, fmt.Errorf("invalid journey ID %q", id)
+	}
+
+	tz, _ := time.LoadLocation("Europe/Brussels")
+	day, err := time.ParseInLocation("20060102", date, tz)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid journey ID %q: %w", id, err)
+	}
+
+	return vehicle, day, nil
+}
+
+func (p *Provider) VehicleToRISJourney(ctx context.Context, journeyID string) (ris.Journey, error) {
+	vehicleID, day, err := parseJourneyID(journeyID)
+	if err != nil {
+		return ris.Journey{}, err
+	}
+
+	vehicle, err := p.GetVehicleRealtime(ctx, vehicleID, day)
+	if err != nil {
+		return ris.Journey{}, err
+	}
+
+	transportType, transportName, transportNumber := transportInfo(vehicle.Vehicleinfo.Shortname)
+
+	out := ris.Journey{
+		JourneyID:      JourneyID(vehicleID, day),
+		Administration: administration,
+		Transport: ris.JourneyTransport{
+			Type:     transportType,
+			Category: transportName,
+			Number:   transportNumber,
+		},
+		Stops: []ris.JourneyStop{},
+	}
+
+	allCanceled := len(vehicle.Stops.Stop) > 0
+	for i, stop := range vehicle.Stops.Stop {
+		journeyStop := ris.JourneyStop{
+			Station: ris.Station{
+				EvaNumber: stop.Stationinfo.ID,
+				Name:      stop.Station,
+			},
+			Platform:         stop.Platforminfo.Name,
+			PlatformSchedule: stop.Platforminfo.Name,
+			Canceled:         stop.Canceled == "1",
+			Additional:       stop.IsExtraStop == "1",
+			Arrived:          stop.Arrived == "1",
+			Departed:         stop.Left == "1",
+		}
+		if stop.Platforminfo.Normal != "1" {
+			journeyStop.PlatformSchedule = "0"
+		}
+
+		// the origin has no arrival and the destination has no departure
+		if i > 0 {
+			journeyStop.Arrival = journeyEvent(stop.ScheduledArrivalTime, stop.ArrivalDelay, stop.ArrivalCanceled)
+		}
+		if i < len(vehicle.Stops.Stop)-1 {
+			journeyStop.Departure = journeyEvent(stop.ScheduledDepartureTime, stop.DepartureDelay, stop.DepartureCanceled)
+		}
+
+		if !journeyStop.Canceled {
+			allCanceled = false
+		}
+		out.Stops = append(out.Stops, journeyStop)
+	}
+
+	if len(out.Stops) > 0 {
+		out.Origin = out.Stops[0].Station
+		out.Destination = out.Stops[len(out.Stops)-1].Station
+	}
+	out.Canceled = allCanceled
+
+	return out, nil
+}
+
+func journeyEvent(scheduled, delay, canceled string) *ris.JourneyEvent {
+	if scheduled == "" {
+		return nil
+	}
+
+	timeSchedule := unixTimeToTime(scheduled)
+	delaySeconds := mustParseInt(delay)
+	timeType := "SCHEDULE"
+	if delaySeconds > 0 {
+		timeType = "PREVIEW"
+	}
+
+	return &ris.JourneyEvent{
+		TimeSchedule: timeSchedule,
+		Time:         timeSchedule.Add(time.Duration(delaySeconds) * time.Second),
+		TimeType:     timeType,
+		Canceled:     canceled == "1",
+	}
+}
@@ -1,35 +1,31 @@
 package irail
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ratelimit"
 	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
 )
 
-const API_URL = "https://api.irail.be"
+// API_URL is the iRail base URL, it can be changed before the first request to use a mirror
+var API_URL = "https://api.irail.be"
+
 const USER_AGENT = "RIS-At-Home/1  (ris.maartje.dev; maartje@eyskens.me)"
 
-var liveboardCache = make(map[string]Liveboard)
-var liveboardCacheMutex sync.RWMutex
-
-func init() {
-	// clear liveboard cache after 5 minutes
-	go func() {
-		for {
-			liveboardCacheMutex.Lock()
-			liveboardCache = make(map[string]Liveboard)
-			liveboardCacheMutex.Unlock()
-			time.Sleep(5 * time.Minute)
-		}
-	}()
-}
+// liveboardHeadroom is the number of entries fetched beyond the board length, so a cached board
+// starting now still fills the board after the first trains left
+const liveboardHeadroom = 10
+
+// LiveboardBucket is the resolution board start and end times are rounded to, requests for a
+// board within the same bucket share one cache entry. iRail itself works per minute.
+var LiveboardBucket = time.Minute
 
 type Departure struct {
 	ID          string `json:"id"`
@@ -67,6 +63,7 @@ type Departure struct {
 		Name string `json:"name"`
 	} `json:"occupancy"`
 	DepartureConnection string `json:"departureConnection"`
+	Alerts              Alerts `json:"alerts"`
 }
 
 type Liveboard struct {
@@ -85,119 +82,205 @@ type Liveboard struct {
 		Number    string      `json:"number"`
 		Departure []Departure `json:"departure"`
 	} `json:"departures"`
+	Arrivals struct {
+		Number  string      `json:"number"`
+		Arrival []Departure `json:"arrival"`
+	} `json:"arrivals"`
 }
 
-func GetLiveboard(station, arriveOrDeparture, lang string, from time.Time) (Liveboard, error) {
-	cacheName := fmt.Sprintf("%s-%s-%s-%d", station, arriveOrDeparture, lang, from.Unix())
-	liveboardCacheMutex.RLock()
-	if liveboard, ok := liveboardCache[cacheName]; ok {
-		liveboardCacheMutex.RUnlock()
-		return liveboard, nil
+// Board is a liveboard collected over all its pages, Liveboard is the last page fetched
+type Board struct {
+	Liveboard Liveboard   `json:"liveboard"`
+	Entries   []Departure `json:"entries"`
+}
+
+// GetBoard returns the departures or arrivals of a station for the board options. The start and
+// end of the board are rounded to LiveboardBucket, so the whole board can be cached as a unit.
+// Boards starting now share one cache entry regardless of the time, which is refreshed in the
+// background once stale, the entries that left since it was fetched are dropped on reading.
+// When too few entries are left the board is fetched again before returning.
+func (p *Provider) GetBoard(ctx context.Context, station, arriveOrDeparture string, opts ris.BoardOptions) (Board, error) {
+	start := opts.Start()
+	cacheOpts := bucketOptions(opts, LiveboardBucket)
+	// short boards are cut from a default board, so they share the entry prewarming fills
+	cacheOpts.MaxResults = max(opts.Limit(), ris.DefaultMaxResults) + liveboardHeadroom
+
+	from := strconv.FormatInt(cacheOpts.TimeStart.Unix(), 10)
+	if opts.TimeStart.IsZero() {
+		from = "now"
 	}
-	liveboardCacheMutex.RUnlock()
+	cacheName := fmt.Sprintf("%s-%s-%s-%s-%d-%d", station, arriveOrDeparture, p.Lang, from, cacheOpts.TimeEnd.Unix(), cacheOpts.Limit())
+	load := func(ctx context.Context) (Board, error) {
+		return getLiveboardEntries(ctx, station, arriveOrDeparture, p.Lang, cacheOpts)
+	}
+	board, err := p.Liveboards.FetchMaxAge(ctx, cacheName, opts.MaxAge, load)
+	if err != nil {
+		return Board{}, err
+	}
+
+	// the cached board starts at the bucket or when it was fetched, which can be well before
+	// the start asked for
+	entries := entriesFrom(board.Entries, start)
+	// a board with fewer entries than fetched for ended with the day or the time window,
+	// otherwise the entries that left have to be made up for
+	if len(entries) < opts.Limit() && len(board.Entries) >= cacheOpts.Limit() {
+		board, err = p.Liveboards.Refresh(ctx, cacheName, load)
+		if err != nil {
+			return Board{}, err
+		}
+		entries = entriesFrom(board.Entries, start)
+	}
+
+	board.Entries = entries[:min(len(entries), opts.Limit())]
+	return board, nil
+}
 
+// entriesFrom returns the entries that leave or arrive at start or later, taking their delay
+// into account. The cached entries are left untouched.
+func entriesFrom(entries []Departure, start time.Time) []Departure {
+	out := make([]Departure, 0, len(entries))
+	for _, entry := range entries {
+		delay, _ := delayAndTimeType(entry)
+		if unixTimeToTime(entry.Time).Add(time.Duration(delay) * time.Second).Before(start) {
+			continue
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// bucketOptions rounds the start of the board down and the end of the board up to the bucket
+func bucketOptions(opts ris.BoardOptions, bucket time.Duration) ris.BoardOptions {
+	if bucket <= 0 {
+		bucket = time.Minute
+	}
+	opts.TimeStart = opts.Start().Truncate(bucket)
+	if !opts.TimeEnd.IsZero() {
+		end := opts.TimeEnd.Truncate(bucket)
+		if end.Before(opts.TimeEnd) {
+			end = end.Add(bucket)
+		}
+		opts.TimeEnd = end
+	}
+	return opts
+}
+
+func fetchLiveboard(ctx context.Context, station, arriveOrDeparture, lang string, from time.Time) (Liveboard, error) {
 	tz, _ := time.LoadLocation("Europe/Brussels")
 	from = from.In(tz)
 	date := from.Format("02012006")
 	time := from.Format("1504")
 
-	url := fmt.Sprintf("%s/liveboard/?id=BE.NMBS.%s&arrdep=%s&lang=%s&format=json&alerts=false&date=%s&time=%s", API_URL, station, arriveOrDeparture, lang, date, time)
+	url := fmt.Sprintf("%s/liveboard/?id=BE.NMBS.%s&arrdep=%s&lang=%s&format=json&alerts=true&date=%s&time=%s", API_URL, station, arriveOrDeparture, lang, date, time)
 	log.Println(url)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return Liveboard{}, err
 	}
 	req.Header.Set("User-Agent", USER_AGENT)
 
-	client := newClient()
+	client := ratelimit.NewClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return Liveboard{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Liveboard{}, fmt.Errorf("irail: unexpected status %s", resp.Status)
+	}
 
 	var liveboard Liveboard
 	if err := json.NewDecoder(resp.Body).Decode(&liveboard); err != nil {
 		return Liveboard{}, err
 	}
 
-	liveboardCacheMutex.Lock()
-	liveboardCache[cacheName] = liveboard
-	liveboardCacheMutex.Unlock()
-
 	return liveboard, nil
 }
 
-func LiveboardToRISDepartures(station, lang string) ([]ris.Departure, error) {
-	out := []ris.Departure{}
+// getLiveboardEntries pages through the liveboard from the start of the board until it has
+// collected the requested number of departures or arrivals, or passed the end of the board
+func getLiveboardEntries(ctx context.Context, station, arriveOrDeparture, lang string, opts ris.BoardOptions) (Board, error) {
 	var liveboard Liveboard
-	var sncbDepartures []Departure
-	fromTime := time.Now()
+	var entries []Departure
+	fromTime := opts.Start()
 	nilAttempts := 0
+	limit := opts.Limit()
+
+	for len(entries) < limit {
+		if !opts.TimeEnd.IsZero() && fromTime.After(opts.TimeEnd) {
+			break
+		}
 
-	for len(sncbDepartures) < 30 {
-		resp, err := GetLiveboard(station, "departures", lang, fromTime)
+		resp, err := fetchLiveboard(ctx, station, arriveOrDeparture, lang, fromTime)
 		if err != nil {
-			return nil, err
+			return Board{}, err
 		}
 
-		for _, dep := range resp.Departures.Departure {
-			if len(sncbDepartures) > 0 && sncbDepartures[len(sncbDepartures)-1].Vehicle == dep.Vehicle {
+		page := resp.entries(arriveOrDeparture)
+		for _, dep := range page {
+			if len(entries) > 0 && entries[len(entries)-1].Vehicle == dep.Vehicle {
 				continue
 			}
-			sncbDepartures = append(sncbDepartures, dep)
+			entries = append(entries, dep)
 		}
 		liveboard = resp
 
-		if len(sncbDepartures) > 0 {
-			if len(resp.Departures.Departure) == 0 {
-				fromTime = fromTime.Add(1 * time.Hour)
-				nilAttempts++
-				if nilAttempts > 10 {
-					break
-				}
-			} else {
-				newFromTime := unixTimeToTime(sncbDepartures[len(sncbDepartures)-1].Time)
-				if newFromTime == fromTime { // we are at the end of the day
-					newFromTime = newFromTime.Add(1 * time.Hour)
-				}
-				fromTime = newFromTime
+		if len(page) == 0 {
+			fromTime = fromTime.Add(1 * time.Hour)
+			nilAttempts++
+			if nilAttempts > 10 {
+				break
+			}
+		} else if len(entries) > 0 {
+			newFromTime := unixTimeToTime(entries[len(entries)-1].Time)
+			if newFromTime == fromTime { // we are at the end of the day
+				newFromTime = newFromTime.Add(1 * time.Hour)
 			}
+			fromTime = newFromTime
 		}
 	}
 
-	for _, departure := range sncbDepartures {
-		departureTime := unixTimeToTime(departure.Time)
-
-		vehicle, err := GetVehicleCached(departure.Vehicleinfo.ID, "nl", departureTime)
-		if err != nil {
-			return nil, err
+	// the last page can overshoot, don't look up vehicles that will never be shown
+	if !opts.TimeEnd.IsZero() {
+		for i, entry := range entries {
+			if unixTimeToTime(entry.Time).After(opts.TimeEnd) {
+				entries = entries[:i]
+				break
+			}
 		}
+	}
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
 
-		platformNormal := departure.Platforminfo.Name
-		if departure.Platforminfo.Normal != "1" {
-			platformNormal = "0"
-		}
+	return Board{Liveboard: liveboard, Entries: entries}, nil
+}
 
-		delay := mustParseInt(departure.Delay)
-		timeType := "SCHEDULE"
-		if delay > 0 {
-			timeType = "PREVIEW"
-		}
+func (l Liveboard) entries(arriveOrDeparture string) []Departure {
+	if arriveOrDeparture == "arrivals" {
+		return l.Arrivals.Arrival
+	}
+	return l.Departures.Departure
+}
 
-		transportType := "HIGH_SPEED_TRAIN"
-		transportNumber := 0
-		sncbShortname := strings.Split(departure.Vehicleinfo.Shortname, " ")
-		transportName := sncbShortname[0]
-		if len(sncbShortname) > 1 {
-			transportNumber = mustParseInt(sncbShortname[1])
-		}
-		if strings.HasPrefix(transportName, "S") || strings.HasPrefix(transportName, "L") {
-			transportType = "REGIONAL_TRAIN"
-		}
-		if strings.HasPrefix(transportName, "BUS") {
-			transportType = "BUS"
-		}
+func (p *Provider) LiveboardToRISDepartures(ctx context.Context, station string, opts ris.BoardOptions) ([]ris.Departure, error) {
+	out := []ris.Departure{}
+	board, err := p.GetBoard(ctx, station, "departures", opts)
+	if err != nil {
+		return nil, err
+	}
+	liveboard, sncbDepartures := board.Liveboard, board.Entries
+
+	vehicles := p.getVehicles(ctx, sncbDepartures)
+	for i, departure := range sncbDepartures {
+		departureTime := unixTimeToTime(departure.Time)
+		vehicle := vehicles[i]
+
+		platformNormal := scheduledPlatform(departure)
+		delay, timeType := delayAndTimeType(departure)
+		journeyID := JourneyID(departure.Vehicle, departureTime)
+		messages, disruptions := alertsToRIS(departure.Alerts)
+		transportType, transportName, transportNumber := transportInfo(departure.Vehicleinfo.Shortname)
 
 		stops := []ris.StopPlace{}
 		vias := []ris.Via{}
@@ -231,33 +314,31 @@ func LiveboardToRISDepartures(station, lang string) ([]ris.Departure, error) {
 				EvaNumber: liveboard.Stationinfo.ID,
 				Name:      liveboard.Stationinfo.Name,
 			},
-			JourneyID:        departure.ID,
-			DepartureID:      departure.ID,
+			JourneyID:        journeyID,
+			DepartureID:      journeyID + "@" + liveboard.Stationinfo.ID,
 			TimeSchedule:     departureTime,
 			Time:             departureTime.Add(time.Duration(delay) * time.Second),
 			TimeType:         timeType,
 			Platform:         departure.Platforminfo.Name,
 			PlatformSchedule: platformNormal,
-			Administration: ris.Administration{
-				AdministrationID: "80",
-				OperatorCode:     "---",
-				OperatorName:     "NMBS",
-			},
-			Disruptions:    []any{},
-			Attributes:     []ris.Attribute{},
-			Messages:       []ris.Message{},
-			JourneyType:    "REGULAR",
-			ReliefFor:      []any{},
-			ReliefBy:       []any{},
-			ReplacementFor: []any{},
-			TravelsWith:    []any{},
-			Codeshares:     []any{},
+			Administration:   administration,
+			Disruptions:      disruptions,
+			Attributes:       []ris.Attribute{},
+			Messages:         messages,
+			JourneyType:      "REGULAR",
+			Additional:       departure.IsExtra == "1",
+			Canceled:         departure.Canceled == "1",
+			ReliefFor:        []ris.TransportRef{},
+			ReliefBy:         []ris.TransportRef{},
+			ReplacementFor:   []ris.TransportRef{},
+			TravelsWith:      []ris.TravelsWith{},
+			Codeshares:       []ris.Codeshare{},
 			Transport: ris.Transport{
 				Type:      transportType,
 				Category:  transportName,
 				Number:    transportNumber,
 				Label:     "",
-				JourneyID: departure.ID,
+				JourneyID: journeyID,
 				Direction: ris.Direction{
 					Text:       departure.Station,
 					StopPlaces: stops,
@@ -275,6 +356,47 @@ func LiveboardToRISDepartures(station, lang string) ([]ris.Departure, error) {
 	return out, nil
 }
 
+var administration = ris.Administration{
+	AdministrationID: "80",
+	OperatorCode:     "---",
+	OperatorName:     "NMBS",
+}
+
+// scheduledPlatform returns the planned platform, or "0" when the train was moved to another platform
+func scheduledPlatform(entry Departure) string {
+	if entry.Platforminfo.Normal != "1" {
+		return "0"
+	}
+	return entry.Platforminfo.Name
+}
+
+func delayAndTimeType(entry Departure) (int, string) {
+	delay := mustParseInt(entry.Delay)
+	if delay > 0 {
+		return delay, "PREVIEW"
+	}
+	return delay, "SCHEDULE"
+}
+
+// transportInfo maps the NMBS vehicle short name (eg. "IC 1234") to a RIS type, category and number
+func transportInfo(shortname string) (string, string, int) {
+	transportType := "HIGH_SPEED_TRAIN"
+	transportNumber := 0
+	sncbShortname := strings.Split(shortname, " ")
+	transportName := sncbShortname[0]
+	if len(sncbShortname) > 1 {
+		transportNumber = mustParseInt(sncbShortname[1])
+	}
+	if strings.HasPrefix(transportName, "S") || strings.HasPrefix(transportName, "L") {
+		transportType = "REGIONAL_TRAIN"
+	}
+	if strings.HasPrefix(transportName, "BUS") {
+		transportType = "BUS"
+	}
+
+	return transportType, transportName, transportNumber
+}
+
 func unixTimeToTime(in string) time.Time {
 	i, err := strconv.ParseInt(in, 10, 64)
 	if err != nil {
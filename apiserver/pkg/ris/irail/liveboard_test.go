@@ -0,0 +1,128 @@
+package irail
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// fakeLiveboard serves pages of 10 departures, 5 minutes apart from the requested time
+func fakeLiveboard(t *testing.T, hits *int32) *httptest.Server {
+	t.Helper()
+	tz, _ := time.LoadLocation("Europe/Brussels")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		from, err := time.ParseInLocation("020120061504", r.URL.Query().Get("date")+r.URL.Query().Get("time"), tz)
+		if err != nil {
+			t.Errorf("invalid liveboard time: %v", err)
+		}
+
+		var departures []string
+		for i := 0; i < 10; i++ {
+			at := from.Add(time.Duration(i) * 5 * time.Minute).Unix()
+			departures = append(departures, fmt.Sprintf(`{"vehicle":"BE.NMBS.IC%d","time":"%d","delay":"0"}`, at, at))
+		}
+		fmt.Fprintf(w, `{"station":"Brussel-Centraal","departures":{"departure":[%s]}}`, strings.Join(departures, ","))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setupLiveboard(t *testing.T, bucket time.Duration) (*Provider, *int32) {
+	t.Helper()
+	var hits int32
+	srv := fakeLiveboard(t, &hits)
+
+	oldURL, oldBucket := API_URL, LiveboardBucket
+	t.Cleanup(func() {
+		API_URL, LiveboardBucket = oldURL, oldBucket
+	})
+	API_URL = srv.URL
+	LiveboardBucket = bucket
+
+	p := &Provider{
+		Lang:       "nl",
+		Liveboards: cache.New[string, Board](5*time.Minute, 100),
+		Vehicles:   cache.New[string, Vehicle](time.Hour, 100),
+		Journeys:   cache.New[string, Vehicle](time.Minute, 100),
+	}
+	return p, &hits
+}
+
+func TestGetBoardCachesWithinBucket(t *testing.T) {
+	p, hits := setupLiveboard(t, time.Hour)
+	start := time.Now().Truncate(time.Hour).Add(10 * time.Minute)
+
+	first, err := p.GetBoard(context.Background(), "008813003", "departures", ris.BoardOptions{TimeStart: start})
+	if err != nil {
+		t.Fatalf("first GetBoard: %v", err)
+	}
+	pages := atomic.LoadInt32(hits)
+	if pages == 0 {
+		t.Fatal("first GetBoard did not hit the network")
+	}
+
+	second, err := p.GetBoard(context.Background(), "008813003", "departures", ris.BoardOptions{TimeStart: start.Add(20 * time.Minute)})
+	if err != nil {
+		t.Fatalf("second GetBoard: %v", err)
+	}
+	if got := atomic.LoadInt32(hits); got != pages {
+		t.Errorf("second GetBoard in the same bucket made %d upstream calls, want 0", got-pages)
+	}
+	if len(first.Entries) == 0 || len(second.Entries) == 0 {
+		t.Errorf("got %d and %d entries, want both boards to have entries", len(first.Entries), len(second.Entries))
+	}
+}
+
+func TestGetBoardDropsEntriesBeforeStart(t *testing.T) {
+	p, _ := setupLiveboard(t, 15*time.Minute)
+	start := time.Now().Truncate(15 * time.Minute).Add(11 * time.Minute)
+
+	board, err := p.GetBoard(context.Background(), "008813003", "departures", ris.BoardOptions{TimeStart: start, MaxResults: 5})
+	if err != nil {
+		t.Fatalf("GetBoard: %v", err)
+	}
+	if len(board.Entries) != 5 {
+		t.Errorf("got %d entries, want 5", len(board.Entries))
+	}
+	for _, entry := range board.Entries {
+		if at := unixTimeToTime(entry.Time); at.Before(start) {
+			t.Errorf("entry at %s is before the board start %s", at, start)
+		}
+	}
+}
+
+func TestGetBoardSharesBoardStartingNow(t *testing.T) {
+	p, hits := setupLiveboard(t, time.Minute)
+
+	first, err := p.GetBoard(context.Background(), "008813003", "departures", ris.BoardOptions{})
+	if err != nil {
+		t.Fatalf("first GetBoard: %v", err)
+	}
+	pages := atomic.LoadInt32(hits)
+	if pages == 0 {
+		t.Fatal("first GetBoard did not hit the network")
+	}
+
+	second, err := p.GetBoard(context.Background(), "008813003", "departures", ris.BoardOptions{})
+	if err != nil {
+		t.Fatalf("second GetBoard: %v", err)
+	}
+	if got := atomic.LoadInt32(hits); got != pages {
+		t.Errorf("second GetBoard starting now made %d upstream calls, want 0", got-pages)
+	}
+	for _, board := range []Board{first, second} {
+		if len(board.Entries) != ris.DefaultMaxResults {
+			t.Errorf("got %d entries, want %d", len(board.Entries), ris.DefaultMaxResults)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package irail
+
+import (
+	"context"
+	"strings"
+
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ris"
+)
+
+// Provider serves NMBS/SNCB stations through the iRail API
+type Provider struct {
+	Lang string
+
+	// Liveboards holds complete paginated boards
+	Liveboards *cache.Cache[string, Board]
+	// Vehicles holds vehicle journeys per service day for the vias on boards
+	Vehicles *cache.Cache[string, Vehicle]
+	// Journeys holds vehicle journeys for the journey endpoint, which shows realtime data
+	// and needs a short TTL
+	Journeys *cache.Cache[string, Vehicle]
+}
+
+func (p *Provider) Name() string {
+	return "irail"
+}
+
+// Match matches the UIC station codes used by NMBS, which all start with 008
+func (p *Provider) Match(id string) bool {
+	return strings.HasPrefix(id, "008")
+}
+
+func (p *Provider) Departures(ctx context.Context, id string, opts ris.BoardOptions) ([]ris.Departure, error) {
+	return p.LiveboardToRISDepartures(ctx, id, opts)
+}
+
+func (p *Provider) Arrivals(ctx context.Context, id string, opts ris.BoardOptions) ([]ris.Arrival, error) {
+	return p.LiveboardToRISArrivals(ctx, id, opts)
+}
+
+func (p *Provider) Journey(ctx context.Context, journeyID string) (ris.Journey, error) {
+	return p.VehicleToRISJourney(ctx, journeyID)
+}
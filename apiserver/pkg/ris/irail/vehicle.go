@@ -1,27 +1,18 @@
 package irail
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
-)
 
-var vehicleCacheMutex sync.RWMutex
-var vehicleCache = make(map[string]Vehicle)
-
-func init() {
-	// clear Vehicle cache after 48 hours
-	go func() {
-		for {
-			vehicleCacheMutex.Lock()
-			vehicleCache = make(map[string]Vehicle)
-			vehicleCacheMutex.Unlock()
-			time.Sleep(48 * time.Hour)
-		}
-	}()
-}
+	"github.com/meyskens/ris-at-home/apiserver/pkg/cache"
+	"github.com/meyskens/ris-at-home/apiserver/pkg/ratelimit"
+)
 
 type Vehicle struct {
 	Version     string `json:"version"`
@@ -75,39 +66,95 @@ type Vehicle struct {
 	} `json:"stops"`
 }
 
-func GetVehicleCached(id, lang string, date time.Time) (Vehicle, error) {
-	dateString := date.Format("02012006")
-	cacheName := id + dateString
-	vehicleCacheMutex.RLock()
-	if vehicle, ok := vehicleCache[cacheName]; ok {
-		vehicleCacheMutex.RUnlock()
-		return vehicle, nil
+// VehicleConcurrency limits the parallel vehicle lookups of a single board
+var VehicleConcurrency = 4
+
+// getVehicles looks up the vehicle of every liveboard entry in parallel. Entries whose vehicle
+// can not be fetched get an empty Vehicle, so a failing lookup only costs that entry its vias.
+func (p *Provider) getVehicles(ctx context.Context, entries []Departure) []Vehicle {
+	vehicles := make([]Vehicle, len(entries))
+	sem := make(chan struct{}, max(VehicleConcurrency, 1))
+	var wg sync.WaitGroup
+
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			vehicle, err := p.GetVehicleCached(ctx, entry.Vehicle, unixTimeToTime(entry.Time))
+			if err != nil {
+				log.Printf("irail: could not get vehicle %s: %v", entry.Vehicle, err)
+				return
+			}
+			vehicles[i] = vehicle
+		}()
+	}
+	wg.Wait()
+
+	return vehicles
+}
+
+// GetVehicleCached returns the vehicle (eg. BE.NMBS.IC1234) running on the service day of date
+func (p *Provider) GetVehicleCached(ctx context.Context, id string, date time.Time) (Vehicle, error) {
+	return getVehicle(ctx, p.Vehicles, id, p.Lang, date)
+}
+
+// GetVehicleRealtime is GetVehicleCached for views showing the realtime state of the vehicle
+func (p *Provider) GetVehicleRealtime(ctx context.Context, id string, date time.Time) (Vehicle, error) {
+	return getVehicle(ctx, p.Journeys, id, p.Lang, date)
+}
+
+func getVehicle(ctx context.Context, c *cache.Cache[string, Vehicle], id, lang string, date time.Time) (Vehicle, error) {
+	id = vehicleID(id)
+	tz, _ := time.LoadLocation("Europe/Brussels")
+	dateString := date.In(tz).Format("02012006")
+	cacheName := fmt.Sprintf("%s-%s-%s", id, dateString, lang)
+	return c.Fetch(ctx, cacheName, func(ctx context.Context) (Vehicle, error) {
+		return fetchVehicle(ctx, id, lang, dateString)
+	})
+}
+
+// vehicleID normalises the vehicle URI iRail uses in vehicleinfo (http://irail.be/vehicle/IC1234)
+// to the vehicle ID of the liveboard (BE.NMBS.IC1234)
+func vehicleID(id string) string {
+	if i := strings.LastIndex(id, "/"); i >= 0 {
+		id = id[i+1:]
+	}
+	if !strings.HasPrefix(id, "BE.NMBS.") {
+		id = "BE.NMBS." + id
 	}
-	vehicleCacheMutex.RUnlock()
+	return id
+}
 
+func fetchVehicle(ctx context.Context, id, lang, dateString string) (Vehicle, error) {
 	url := API_URL + "/vehicle/?id=" + id + "&lang=" + lang + "&format=json&alerts=false&date=" + dateString
 	log.Println(url)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return Vehicle{}, err
 	}
 	req.Header.Set("User-Agent", USER_AGENT)
 
-	client := newClient()
+	client := ratelimit.NewClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return Vehicle{}, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Vehicle{}, fmt.Errorf("irail: unexpected status %s", resp.Status)
+	}
 
 	var vehicle Vehicle
 	if err := json.NewDecoder(resp.Body).Decode(&vehicle); err != nil {
 		return Vehicle{}, err
 	}
 
-	vehicleCacheMutex.Lock()
-	vehicleCache[cacheName] = vehicle
-	vehicleCacheMutex.Unlock()
-
 	return vehicle, nil
 }
@@ -0,0 +1,122 @@
+package ris
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxResults is the board length used when a provider has to paginate and no maxResults is given
+	DefaultMaxResults = 30
+	// LimitMaxResults is the largest maxResults a client may ask for
+	LimitMaxResults = 200
+)
+
+// BoardOptions are the RIS query parameters a board is requested with, zero values mean unset
+type BoardOptions struct {
+	MaxResults int
+	TimeStart  time.Time
+	TimeEnd    time.Time
+	// MaxViaStops is nil when unset, as 0 asks for no via stops at all
+	MaxViaStops *int
+
+	// Filter is applied on the merged board, before it is cut to MaxResults
+	Filter Filter
+
+	// MaxAge is how long ago a provider may have fetched the board it serves from its cache,
+	// 0 leaves it to the cache TTL. It is not a query parameter.
+	MaxAge time.Duration
+}
+
+// Limit returns the number of results a paginating provider should collect
+func (o BoardOptions) Limit() int {
+	if o.MaxResults > 0 {
+		return o.MaxResults
+	}
+	return DefaultMaxResults
+}
+
+// Start returns the time the board starts at, which is now when unset
+func (o BoardOptions) Start() time.Time {
+	if o.TimeStart.IsZero() {
+		return time.Now()
+	}
+	return o.TimeStart
+}
+
+// InWindow reports if t falls inside the requested time window
+func (o BoardOptions) InWindow(t time.Time) bool {
+	if !o.TimeStart.IsZero() && t.Before(o.TimeStart) {
+		return false
+	}
+	if !o.TimeEnd.IsZero() && t.After(o.TimeEnd) {
+		return false
+	}
+	return true
+}
+
+// providerOptions returns the options first passed on to providers. When a filter is set the
+// providers are asked for at least a default board, so a short filtered board has more
+// entries to pick from.
+func (o BoardOptions) providerOptions() BoardOptions {
+	if !o.Filter.IsEmpty() && o.MaxResults < DefaultMaxResults {
+		o.MaxResults = DefaultMaxResults
+	}
+	return o
+}
+
+// ParseBoardOptions reads maxResults, timeStart, timeEnd, timeOffset (in minutes from now),
+// maxViaStops and the filter parameters from the query string
+func ParseBoardOptions(query url.Values) (BoardOptions, error) {
+	opts := BoardOptions{
+		Filter: ParseFilter(query),
+	}
+	var err error
+
+	if v := query.Get("maxResults"); v != "" {
+		opts.MaxResults, err = strconv.Atoi(v)
+		if err != nil || opts.MaxResults < 1 || opts.MaxResults > LimitMaxResults {
+			return BoardOptions{}, fmt.Errorf("maxResults must be a number between 1 and %d", LimitMaxResults)
+		}
+	}
+
+	if v := query.Get("maxViaStops"); v != "" {
+		maxViaStops, err := strconv.Atoi(v)
+		if err != nil || maxViaStops < 0 {
+			return BoardOptions{}, fmt.Errorf("maxViaStops must be a number of 0 or more")
+		}
+		opts.MaxViaStops = &maxViaStops
+	}
+
+	if v := query.Get("timeStart"); v != "" {
+		opts.TimeStart, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return BoardOptions{}, fmt.Errorf("timeStart must be an RFC 3339 time: %w", err)
+		}
+	}
+
+	if v := query.Get("timeOffset"); v != "" {
+		if !opts.TimeStart.IsZero() {
+			return BoardOptions{}, fmt.Errorf("timeStart and timeOffset can not be combined")
+		}
+		minutes, err := strconv.Atoi(v)
+		if err != nil {
+			return BoardOptions{}, fmt.Errorf("timeOffset must be a number of minutes")
+		}
+		opts.TimeStart = time.Now().Add(time.Duration(minutes) * time.Minute)
+	}
+
+	if v := query.Get("timeEnd"); v != "" {
+		opts.TimeEnd, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return BoardOptions{}, fmt.Errorf("timeEnd must be an RFC 3339 time: %w", err)
+		}
+		if opts.TimeEnd.Before(opts.Start()) {
+			return BoardOptions{}, fmt.Errorf("timeEnd must be after the start of the board")
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,87 @@
+package ris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrNoProvider is returned when no registered provider can handle a station ID
+var ErrNoProvider = errors.New("no provider found for station")
+
+// ErrNotSupported is returned when a provider does not implement an optional lookup
+var ErrNotSupported = errors.New("not supported by provider")
+
+// Provider is an operator backend that can turn a stop ID into RIS departures
+type Provider interface {
+	// Name is the unique prefix used to address the provider explicitly, e.g. "irail" in "irail:008821006"
+	Name() string
+	// Match reports if the provider handles an ID that was given without a prefix
+	Match(id string) bool
+	// Departures returns the departures for the given stop ID, honouring at least the
+	// count and time window of opts. Fewer departures than the count means there are no
+	// more in the time window.
+	Departures(ctx context.Context, id string, opts BoardOptions) ([]Departure, error)
+}
+
+// ArrivalsProvider is implemented by providers that can return arrivals
+type ArrivalsProvider interface {
+	Arrivals(ctx context.Context, id string, opts BoardOptions) ([]Arrival, error)
+}
+
+// JourneyProvider is implemented by providers that can look up a single journey
+type JourneyProvider interface {
+	Journey(ctx context.Context, journeyID string) (Journey, error)
+}
+
+var (
+	providers      []Provider
+	providersMutex sync.RWMutex
+)
+
+// Register adds a provider to the registry, providers are matched in registration order
+func Register(p Provider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	for _, existing := range providers {
+		if existing.Name() == p.Name() {
+			panic(fmt.Sprintf("ris: provider %q registered twice", p.Name()))
+		}
+	}
+	providers = append(providers, p)
+}
+
+// Providers returns all registered providers
+func Providers() []Provider {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	return append([]Provider{}, providers...)
+}
+
+// Lookup finds the provider for a station ID and returns it together with the ID
+// stripped of any "provider:" prefix
+func Lookup(id string) (Provider, string, error) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	if name, rest, ok := strings.Cut(id, ":"); ok {
+		for _, p := range providers {
+			if p.Name() == name {
+				return p, rest, nil
+			}
+		}
+		return nil, "", fmt.Errorf("%w: %s", ErrNoProvider, id)
+	}
+
+	for _, p := range providers {
+		if p.Match(id) {
+			return p, id, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("%w: %s", ErrNoProvider, id)
+}